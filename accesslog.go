@@ -0,0 +1,114 @@
+package niuhe
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccessLogEntry struct {
+	Time      time.Time   `json:"time"`
+	Route     string      `json:"route,omitempty"`
+	Group     string      `json:"group,omitempty"`
+	Func      string      `json:"func,omitempty"`
+	Method    string      `json:"method"`
+	Path      string      `json:"path"`
+	Status    int         `json:"status"`
+	LatencyMs float64     `json:"latency_ms"`
+	ClientIP  string      `json:"client_ip"`
+	Result    *int        `json:"result,omitempty"`
+	Message   string      `json:"message,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+	User      interface{} `json:"user,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	BodySize  int         `json:"body_size"`
+}
+
+type AccessLogSink interface {
+	WriteAccessLog(entry *AccessLogEntry)
+}
+
+type AccessLogSinkFunc func(entry *AccessLogEntry)
+
+func (f AccessLogSinkFunc) WriteAccessLog(entry *AccessLogEntry) {
+	f(entry)
+}
+
+type jsonAccessLogSink struct {
+	lock sync.Mutex
+	out  io.Writer
+}
+
+// NewJSONAccessLogSink writes one JSON object per line to out.
+func NewJSONAccessLogSink(out io.Writer) AccessLogSink {
+	return &jsonAccessLogSink{out: out}
+}
+
+func (s *jsonAccessLogSink) WriteAccessLog(entry *AccessLogEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		LogError("marshal access log failed: %v", err)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.out.Write(append(line, '\n'))
+}
+
+type AccessLogOptions struct {
+	Sink AccessLogSink // defaults to JSON lines on stderr
+	// User extracts the session user of API calls, e.g. from c.GetSession.
+	User func(c *Context) interface{}
+	// RequestIDHeader is read from the response, then the request headers.
+	// Defaults to "X-Request-Id".
+	RequestIDHeader string
+}
+
+// SetAccessLog replaces the colored gin log lines with structured access log
+// entries carrying what niuhe knows about each call.
+func (svr *Server) SetAccessLog(opts AccessLogOptions) {
+	if opts.Sink == nil {
+		opts.Sink = NewJSONAccessLogSink(os.Stderr)
+	}
+	if opts.RequestIDHeader == "" {
+		opts.RequestIDHeader = "X-Request-Id"
+	}
+	svr.accessLog = &opts
+}
+
+func accessLogMiddleware(opts *AccessLogOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		entry := &AccessLogEntry{
+			Time:      start,
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+			Status:    c.Writer.Status(),
+			LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			ClientIP:  c.ClientIP(),
+			Error:     c.Errors.ByType(gin.ErrorTypePrivate).String(),
+			BodySize:  c.Writer.Size(),
+		}
+		if entry.RequestID = c.Writer.Header().Get(opts.RequestIDHeader); entry.RequestID == "" {
+			entry.RequestID = c.GetHeader(opts.RequestIDHeader)
+		}
+		if context := GetContext(c); context != nil {
+			entry.Route = context.RoutePath()
+			entry.Group, entry.Func = context.RouteNames()
+			if code, message, ok := context.ApiResult(); ok {
+				entry.Result, entry.Message = &code, message
+			}
+			if opts.User != nil {
+				entry.User = opts.User(context)
+			}
+		} else {
+			entry.Route = c.FullPath()
+		}
+		opts.Sink.WriteAccessLog(entry)
+	}
+}
@@ -0,0 +1,31 @@
+package niuhe
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	var entries []*AccessLogEntry
+	mod := NewModule("/api")
+	mod.Register(&IdemTest{})
+	svr := NewServer()
+	svr.RegisterModule(mod)
+	svr.SetAccessLog(AccessLogOptions{
+		Sink: AccessLogSinkFunc(func(entry *AccessLogEntry) {
+			entries = append(entries, entry)
+		}),
+		User: func(c *Context) interface{} { return "tester" },
+	})
+	doRequest(svr.GetGinEngine(), http.MethodPost, "/api/idem_test/create/", map[string]string{"X-Request-Id": "rid-1"})
+
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 access log entry, got %d", len(entries))
+	}
+	e := entries[0]
+	assertTrue(t, e.Route == "/api/idem_test/create/", "route should be logged, got %s", e.Route)
+	assertTrue(t, e.Group == "IdemTest" && e.Func == "Create_POST", "handler names should be logged, got %s %s", e.Group, e.Func)
+	assertTrue(t, e.Result != nil && *e.Result == 0, "result code should be logged")
+	assertTrue(t, e.RequestID == "rid-1", "request id should be logged, got %s", e.RequestID)
+	assertTrue(t, e.User == "tester" && e.Status == 200, "user and status should be logged, got %v %d", e.User, e.Status)
+}
@@ -0,0 +1,229 @@
+package niuhe
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http/pprof"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+type AdminOptions struct {
+	// Auth guards every admin route. It is required since the module exposes
+	// profiling data and lets callers change the log level.
+	Auth HandlerFunc
+	// DBStats reports connection pool statistics, see db.PoolStats.
+	DBStats func() map[string]sql.DBStats
+}
+
+// AdminTokenAuth accepts requests carrying token in the X-Admin-Token header.
+func AdminTokenAuth(token string) HandlerFunc {
+	return func(c *Context) {
+		given := c.GetHeader("X-Admin-Token")
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.AbortWithApiError(NewCommError(-1, "unauthorized"))
+			return
+		}
+		c.Next()
+	}
+}
+
+type adminApi struct {
+	svr  *Server
+	opts AdminOptions
+}
+
+type AdminEmptyReq struct{}
+
+type AdminRouteItem struct {
+	Methods []string `json:"methods"`
+	Path    string   `json:"path"`
+	Group   string   `json:"group"`
+	Func    string   `json:"func"`
+}
+
+type AdminRoutesRsp struct {
+	Routes []AdminRouteItem `json:"routes"`
+}
+
+func (api *adminApi) Routes(c *Context, req *AdminEmptyReq, rsp *AdminRoutesRsp) error {
+	seen := map[*Module]bool{}
+	modules := append([]*Module{}, api.svr.modules...)
+	for _, spec := range api.svr.listenSpecs {
+		modules = append(modules, spec.opts.Modules...)
+	}
+	for _, vh := range api.svr.vhosts {
+		modules = append(modules, vh.modules...)
+	}
+	for _, mod := range modules {
+		if seen[mod] {
+			continue
+		}
+		seen[mod] = true
+		for _, info := range mod.routers {
+			var methods []string
+			if info.Methods&GET != 0 {
+				methods = append(methods, "GET")
+			}
+			if info.Methods&POST != 0 {
+				methods = append(methods, "POST")
+			}
+			rsp.Routes = append(rsp.Routes, AdminRouteItem{
+				Methods: methods,
+				Path:    info.fullPath(),
+				Group:   info.groupName,
+				Func:    info.funcName,
+			})
+		}
+	}
+	return nil
+}
+
+type AdminRuntimeRsp struct {
+	GoVersion    string `json:"go_version"`
+	NumCPU       int    `json:"num_cpu"`
+	NumGoroutine int    `json:"num_goroutine"`
+	HeapAlloc    uint64 `json:"heap_alloc"`
+	HeapInuse    uint64 `json:"heap_inuse"`
+	Sys          uint64 `json:"sys"`
+	NumGC        uint32 `json:"num_gc"`
+}
+
+func (api *adminApi) Runtime(c *Context, req *AdminEmptyReq, rsp *AdminRuntimeRsp) error {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	rsp.GoVersion = runtime.Version()
+	rsp.NumCPU = runtime.NumCPU()
+	rsp.NumGoroutine = runtime.NumGoroutine()
+	rsp.HeapAlloc, rsp.HeapInuse, rsp.Sys, rsp.NumGC = mem.HeapAlloc, mem.HeapInuse, mem.Sys, mem.NumGC
+	return nil
+}
+
+type AdminDBRsp struct {
+	Pools map[string]sql.DBStats `json:"pools"`
+}
+
+func (api *adminApi) DB(c *Context, req *AdminEmptyReq, rsp *AdminDBRsp) error {
+	if api.opts.DBStats == nil {
+		return NewCommError(-1, "db stats are not configured")
+	}
+	rsp.Pools = api.opts.DBStats()
+	return nil
+}
+
+type AdminLogLevelReq struct {
+	Level string `json:"level" zpf_reqd:"true"`
+}
+
+type AdminLogLevelRsp struct {
+	Level string `json:"level"`
+}
+
+func (api *adminApi) GetLogLevel(c *Context, req *AdminEmptyReq, rsp *AdminLogLevelRsp) error {
+	rsp.Level = LogLevelName(LogLevel())
+	return nil
+}
+
+func (api *adminApi) SetLogLevel(c *Context, req *AdminLogLevelReq, rsp *AdminLogLevelRsp) error {
+	level, ok := ParseLogLevelName(strings.ToUpper(req.Level))
+	if !ok {
+		return NewCommError(-1, "unknown log level "+req.Level)
+	}
+	SetLogLevel(level)
+	c.Logger().Warn("log level changed to %s by %s", LogLevelName(level), c.ClientIP())
+	rsp.Level = LogLevelName(level)
+	return nil
+}
+
+type AdminMaintenanceReq struct {
+	On bool `json:"on"`
+}
+
+type AdminMaintenanceRsp struct {
+	On bool `json:"on"`
+}
+
+func (api *adminApi) GetMaintenance(c *Context, req *AdminEmptyReq, rsp *AdminMaintenanceRsp) error {
+	rsp.On = api.svr.InMaintenance()
+	return nil
+}
+
+func (api *adminApi) SetMaintenance(c *Context, req *AdminMaintenanceReq, rsp *AdminMaintenanceRsp) error {
+	if api.svr.maintenance == nil {
+		return NewCommError(-1, "maintenance mode is not enabled")
+	}
+	api.svr.SetMaintenance(req.On)
+	c.Logger().Warn("maintenance set to %v by %s", req.On, c.ClientIP())
+	rsp.On = api.svr.InMaintenance()
+	return nil
+}
+
+func (api *adminApi) PprofIndex(c *Context) {
+	pprof.Index(c.Writer, c.Request)
+}
+
+func (api *adminApi) PprofCmdline(c *Context) {
+	pprof.Cmdline(c.Writer, c.Request)
+}
+
+func (api *adminApi) PprofProfile(c *Context) {
+	pprof.Profile(c.Writer, c.Request)
+}
+
+func (api *adminApi) PprofSymbol(c *Context) {
+	pprof.Symbol(c.Writer, c.Request)
+}
+
+func (api *adminApi) PprofTrace(c *Context) {
+	pprof.Trace(c.Writer, c.Request)
+}
+
+func pprofNamedHandler(name string) func(*adminApi, *Context) {
+	handler := pprof.Handler(name)
+	return func(api *adminApi, c *Context) {
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// NewAdminModule creates a module exposing pprof, the route table, runtime
+// and db pool statistics, and the log level of the default logger:
+//
+//	GET  {prefix}/routes/
+//	GET  {prefix}/runtime/
+//	GET  {prefix}/db/
+//	GET  {prefix}/log_level/
+//	POST {prefix}/log_level/   level=DEBUG|INFO|WARN|ERROR|ASSERT|FATAL
+//	GET  {prefix}/maintenance/
+//	POST {prefix}/maintenance/ on=true|false
+//	GET  {prefix}/pprof/...
+//
+// It is served in maintenance mode too. Serve it on a private address with
+// Server.AddListener where possible.
+func NewAdminModule(urlPrefix string, svr *Server, opts AdminOptions) *Module {
+	if opts.Auth == nil {
+		panic("NewAdminModule: AdminOptions.Auth is required")
+	}
+	api := &adminApi{svr: svr, opts: opts}
+	mod := NewModule(urlPrefix).Use(opts.Auth).SkipMaintenance()
+	groupValue := reflect.ValueOf(api)
+	add := func(methods int, path string, fn interface{}) {
+		mod.AddCustomRoute(methods, path, groupValue, reflect.ValueOf(fn), nil, nil)
+	}
+	add(GET, "/routes/", (*adminApi).Routes)
+	add(GET, "/runtime/", (*adminApi).Runtime)
+	add(GET, "/db/", (*adminApi).DB)
+	add(GET, "/log_level/", (*adminApi).GetLogLevel)
+	add(POST, "/log_level/", (*adminApi).SetLogLevel)
+	add(GET, "/maintenance/", (*adminApi).GetMaintenance)
+	add(POST, "/maintenance/", (*adminApi).SetMaintenance)
+	add(GET, "/pprof/", (*adminApi).PprofIndex)
+	add(GET, "/pprof/cmdline/", (*adminApi).PprofCmdline)
+	add(GET, "/pprof/profile/", (*adminApi).PprofProfile)
+	add(GET_POST, "/pprof/symbol/", (*adminApi).PprofSymbol)
+	add(GET, "/pprof/trace/", (*adminApi).PprofTrace)
+	for _, name := range []string{"allocs", "block", "goroutine", "heap", "mutex", "threadcreate"} {
+		add(GET, "/pprof/"+name+"/", pprofNamedHandler(name))
+	}
+	return mod
+}
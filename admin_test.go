@@ -0,0 +1,78 @@
+package niuhe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminModule(t *testing.T) {
+	svr := NewServer()
+	svr.RegisterModule(NewModule("/api").Register(&IdemTest{}))
+	svr.RegisterModule(NewAdminModule("/admin", svr, AdminOptions{Auth: AdminTokenAuth("secret")}))
+	engine := svr.GetGinEngine()
+	auth := map[string]string{"X-Admin-Token": "secret"}
+
+	w := doRequest(engine, http.MethodGet, "/admin/routes/", nil)
+	assertTrue(t, strings.Contains(w.Body.String(), `"result":-1`), "admin routes need the token, got %s", w.Body)
+	w = doRequest(engine, http.MethodGet, "/admin/routes/", auth)
+	assertTrue(t, strings.Contains(w.Body.String(), `"path":"/api/idem_test/create/"`), "route table should be listed, got %s", w.Body)
+	w = doRequest(engine, http.MethodGet, "/admin/pprof/goroutine/?debug=1", auth)
+	assertTrue(t, w.Code == 200 && strings.Contains(w.Body.String(), "goroutine profile"), "pprof should be served, got %d", w.Code)
+
+	level := LogLevel()
+	defer SetLogLevel(level)
+	req := httptest.NewRequest(http.MethodPost, "/admin/log_level/", strings.NewReader(url.Values{"level": {"debug"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Admin-Token", "secret")
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	assertTrue(t, LogLevel() == LOG_DEBUG, "log level should be changed, got %s (%s)", LogLevelName(LogLevel()), rec.Body)
+	w = doRequest(engine, http.MethodGet, "/admin/log_level/", auth)
+	assertTrue(t, strings.Contains(w.Body.String(), `"level":"DEBUG"`), "log level should be reported, got %s", w.Body)
+}
+
+func TestAdminTurnsMaintenanceOff(t *testing.T) {
+	svr := NewServer()
+	svr.EnableMaintenance(MaintenanceOptions{})
+	svr.RegisterModule(NewModule("/api").Register(&IdemTest{}))
+	svr.RegisterModule(NewAdminModule("/admin", svr, AdminOptions{Auth: AdminTokenAuth("secret")}))
+	engine := svr.GetGinEngine()
+	svr.SetMaintenance(true)
+
+	w := doRequest(engine, http.MethodPost, "/api/idem_test/create/", nil)
+	assertTrue(t, strings.Contains(w.Body.String(), "service under maintenance"), "api should be rejected in maintenance, got %s", w.Body)
+	req := httptest.NewRequest(http.MethodPost, "/admin/maintenance/", strings.NewReader(url.Values{"on": {"false"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Admin-Token", "secret")
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	assertTrue(t, strings.Contains(rec.Body.String(), `"on":false`), "admin should turn maintenance off, got %s", rec.Body)
+	assertTrue(t, !svr.InMaintenance(), "maintenance should be off")
+	w = doRequest(engine, http.MethodPost, "/api/idem_test/create/", nil)
+	assertTrue(t, strings.Contains(w.Body.String(), `"result":0`), "api should be served again, got %s", w.Body)
+}
+
+func TestAdminRoutesWithPathPrefix(t *testing.T) {
+	svr := NewServer()
+	svr.SetPathPrefix("/svc")
+	svr.RegisterModule(NewModule("/api").Register(&IdemTest{}))
+	svr.RegisterModule(NewAdminModule("/admin", svr, AdminOptions{Auth: AdminTokenAuth("secret")}))
+	var route string
+	svr.Use(func(c *gin.Context) {
+		c.Next()
+		if context := GetContext(c); context != nil {
+			route = context.RoutePath()
+		}
+	})
+	engine := svr.GetGinEngine()
+
+	doRequest(engine, http.MethodPost, "/svc/api/idem_test/create/", nil)
+	assertTrue(t, route == "/svc/api/idem_test/create/", "route path should include the server prefix, got %q", route)
+	w := doRequest(engine, http.MethodGet, "/svc/admin/routes/", map[string]string{"X-Admin-Token": "secret"})
+	assertTrue(t, strings.Contains(w.Body.String(), `"path":"/svc/api/idem_test/create/"`), "route table should match the route path, got %s", w.Body)
+}
@@ -7,7 +7,9 @@ import (
 	"math"
 	"reflect"
 	"regexp"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -33,20 +35,57 @@ func RegisterInjector(t reflect.Type, injector Injector) {
 type HandlerFunc func(*Context)
 
 type routeInfo struct {
-	Methods     int
-	Path        string
-	HandleFunc  gin.HandlerFunc
-	groupValue  reflect.Value
-	funcValue   reflect.Value
-	pf          IApiProtocolFactory
-	middlewares []HandlerFunc
+	Methods        int
+	Path           string
+	HandleFunc     gin.HandlerFunc
+	svrPrefix      string // Server.PathPrefix without the trailing "/", set by buildEngine
+	prefix         string
+	groupName      string
+	funcName       string
+	groupValue     reflect.Value
+	funcValue      reflect.Value
+	pf             IApiProtocolFactory
+	middlewares    []HandlerFunc
+	envelope       *EnvelopeConfig
+	writeErrorHook WriteErrorHook
+}
+
+func (info *routeInfo) fullPath() string {
+	return info.svrPrefix + info.prefix + info.Path
+}
+
+// funcNames splits the runtime name of a handler, such as
+// "pkg.(*UserApi).Login_POST", into its group and method names.
+func funcNames(funcValue reflect.Value) (group, method string) {
+	if funcValue.Kind() != reflect.Func {
+		return "", ""
+	}
+	fn := runtime.FuncForPC(funcValue.Pointer())
+	if fn == nil {
+		return "", ""
+	}
+	name := fn.Name()
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:]
+	}
+	parts := strings.Split(name, ".")
+	if len(parts) < 3 {
+		return "", parts[len(parts)-1]
+	}
+	group = strings.TrimSuffix(strings.TrimPrefix(parts[len(parts)-2], "(*"), ")")
+	return group, parts[len(parts)-1]
 }
 
 type Module struct {
-	urlPrefix   string
-	middlewares []HandlerFunc
-	routers     []*routeInfo
-	pf          IApiProtocolFactory
+	urlPrefix      string
+	middlewares    []HandlerFunc
+	routers        []*routeInfo
+	pf             IApiProtocolFactory
+	jsonrpcPath    string
+	envelope       *EnvelopeConfig
+	writeErrorHook WriteErrorHook
+	// skipMaintenance serves the module while in maintenance, see SkipMaintenance
+	skipMaintenance bool
 }
 
 func NewModule(urlPrefix string) *Module {
@@ -91,9 +130,13 @@ type IModuleGroup interface {
 
 func (mod *Module) AddCustomRoute(methods int, path string, groupValue, funcValue reflect.Value,
 	pf IApiProtocolFactory, middlewares []HandlerFunc) {
+	groupName, funcName := funcNames(funcValue)
 	mod.routers = append(mod.routers, &routeInfo{
 		Methods:     methods,
 		Path:        path,
+		prefix:      mod.urlPrefix,
+		groupName:   groupName,
+		funcName:    funcName,
 		groupValue:  groupValue,
 		funcValue:   funcValue,
 		pf:          pf,
@@ -156,7 +199,11 @@ func makeIOCloserDisposer(v io.Closer) func() {
 	}
 }
 
-func getApiGinFunc(groupValue reflect.Value, path string, funcValue reflect.Value, reqType, rspType reflect.Type, injectTypes []reflect.Type, pf IApiProtocolFactory, middlewares []HandlerFunc) gin.HandlerFunc {
+func getApiGinFunc(info *routeInfo, reqType, rspType reflect.Type, injectTypes []reflect.Type, middlewares []HandlerFunc, defaultPf IApiProtocolFactory) gin.HandlerFunc {
+	groupValue, path, funcValue, pf := info.groupValue, info.Path, info.funcValue, info.pf
+	if pf == nil {
+		pf = defaultPf
+	}
 	injectors := make([]Injector, len(injectTypes))
 	for i, t := range injectTypes {
 		injector := globalInjectors[t]
@@ -167,17 +214,22 @@ func getApiGinFunc(groupValue reflect.Value, path string, funcValue reflect.Valu
 	}
 	return func(c *gin.Context) {
 		context := newContext(c, middlewares)
+		context.route = info
+		context.reqType = reqType
+		context.rspType = rspType
+		if call := jsonrpcCallOf(c); call != nil {
+			context.protocol = call
+		} else if pf == nil {
+			context.protocol = GetDefaultProtocolFactory().GetProtocol()
+		} else {
+			context.protocol = pf.GetProtocol()
+		}
 		context.handlers = append(context.handlers, func(c *Context) {
-			req := reflect.New(reqType)
+			req, readErr := context.readRequest()
 			rsp := reflect.New(rspType)
 			var ierr interface{}
-			var protocol IApiProtocol
-			if pf == nil {
-				protocol = GetDefaultProtocolFactory().GetProtocol()
-			} else {
-				protocol = pf.GetProtocol()
-			}
-			if readErr := protocol.Read(context, req); readErr != nil {
+			protocol := context.protocol
+			if readErr != nil {
 				ierr = readErr
 			} else {
 				disposers := []func(){}
@@ -192,8 +244,11 @@ func getApiGinFunc(groupValue reflect.Value, path string, funcValue reflect.Valu
 					req,
 					rsp,
 				}
-				for _, injector := range injectors {
-					if injectValue, err := injector(context, req.Interface()); err != nil {
+				for i, injector := range injectors {
+					injectStart := time.Now()
+					injectValue, err := injector(context, req.Interface())
+					context.injected = append(context.injected, injectTiming{injectTypes[i], time.Since(injectStart)})
+					if err != nil {
 						ierr = err
 						break
 					} else {
@@ -227,17 +282,17 @@ func getApiGinFunc(groupValue reflect.Value, path string, funcValue reflect.Valu
 			} else {
 				rspErr = nil
 			}
-			if err := protocol.Write(context, rsp, rspErr); err != nil {
-				panic(err)
-			}
+			context.writeApiResponse(protocol, rsp, rspErr)
 		})
 		context.Next()
 	}
 }
 
-func getWebGinFunc(groupValue, funcValue reflect.Value, middlewares []HandlerFunc) gin.HandlerFunc {
+func getWebGinFunc(info *routeInfo, middlewares []HandlerFunc) gin.HandlerFunc {
+	groupValue, funcValue := info.groupValue, info.funcValue
 	return func(c *gin.Context) {
 		context := newContext(c, middlewares)
+		context.route = info
 		context.handlers = append(context.handlers, func(c *Context) {
 			funcValue.Call([]reflect.Value{
 				groupValue,
@@ -248,10 +303,8 @@ func getWebGinFunc(groupValue, funcValue reflect.Value, middlewares []HandlerFun
 	}
 }
 
-func getGinFunc(
-	groupValue reflect.Value, methods int, path string, funcValue reflect.Value, pf IApiProtocolFactory, middlewares []HandlerFunc,
-) (ginHandler gin.HandlerFunc) {
-	funcType := funcValue.Type()
+func getGinFunc(info *routeInfo, middlewares []HandlerFunc, defaultPf IApiProtocolFactory) (ginHandler gin.HandlerFunc) {
+	funcType := info.funcValue.Type()
 	if funcType.Kind() != reflect.Func {
 		panic("handleFunc必须为函数")
 	}
@@ -271,21 +324,28 @@ func getGinFunc(
 		for i := 4; i < numIn; i++ {
 			injectTypes[i-4] = funcType.In(i)
 		}
-		ginHandler = getApiGinFunc(groupValue, path, funcValue, reqType, rspType, injectTypes, pf, middlewares)
+		ginHandler = getApiGinFunc(info, reqType, rspType, injectTypes, middlewares, defaultPf)
 	} else {
-		ginHandler = getWebGinFunc(groupValue, funcValue, middlewares)
+		ginHandler = getWebGinFunc(info, middlewares)
 	}
 	return
 }
 
 func (mod *Module) Routers(svrMiddlewares []HandlerFunc) []*routeInfo {
+	return mod.routersWithDefault(svrMiddlewares, nil)
+}
+
+// routersWithDefault builds the handlers of the routes, using defaultPf for
+// routes registered without a protocol factory.
+func (mod *Module) routersWithDefault(svrMiddlewares []HandlerFunc, defaultPf IApiProtocolFactory) []*routeInfo {
 	for _, router := range mod.routers {
 		middlewares := make([]HandlerFunc, 0, len(svrMiddlewares)+len(mod.middlewares)+len(router.middlewares))
 		middlewares = append(middlewares, svrMiddlewares...)
 		middlewares = append(middlewares, mod.middlewares...)
 		middlewares = append(middlewares, router.middlewares...)
-		router.HandleFunc = getGinFunc(
-			router.groupValue, router.Methods, router.Path, router.funcValue, router.pf, middlewares)
+		router.envelope = mod.envelope
+		router.writeErrorHook = mod.writeErrorHook
+		router.HandleFunc = getGinFunc(router, middlewares, defaultPf)
 	}
 	return mod.routers
 }
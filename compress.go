@@ -0,0 +1,220 @@
+package niuhe
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var DefaultCompressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/x-msgpack",
+	"image/svg+xml",
+}
+
+type CompressionOptions struct {
+	Level   int // compression level, 0 for the default one
+	MinSize int // responses smaller than this are sent as is, defaults to 1024
+	// ContentTypes lists compressible media types; entries ending with "/"
+	// match a whole family. Defaults to DefaultCompressibleTypes.
+	ContentTypes []string
+}
+
+// EnableCompression compresses responses with gzip or deflate according to
+// the Accept-Encoding of each request.
+func (svr *Server) EnableCompression(opts ...CompressionOptions) {
+	var o CompressionOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	svr.compression = CompressionMiddleware(o)
+}
+
+// CompressionMiddleware buffers the beginning of the response until MinSize
+// bytes are written, so headers set late by output methods (e.g. the session
+// cookie saved by Context.JSON) are still sent.
+func CompressionMiddleware(opts CompressionOptions) gin.HandlerFunc {
+	if opts.Level == 0 {
+		opts.Level = gzip.DefaultCompression
+	}
+	if opts.MinSize <= 0 {
+		opts.MinSize = 1024
+	}
+	if opts.ContentTypes == nil {
+		opts.ContentTypes = DefaultCompressibleTypes
+	}
+	pools := map[string]*sync.Pool{
+		"gzip": {New: func() interface{} {
+			w, _ := gzip.NewWriterLevel(io.Discard, opts.Level)
+			return w
+		}},
+		"deflate": {New: func() interface{} {
+			w, _ := zlib.NewWriterLevel(io.Discard, opts.Level)
+			return w
+		}},
+	}
+	return func(c *gin.Context) {
+		cw := &compressWriter{
+			ResponseWriter: c.Writer,
+			opts:           &opts,
+			encoding:       acceptedEncoding(c.GetHeader("Accept-Encoding")),
+		}
+		if cw.encoding != "" {
+			cw.pool = pools[cw.encoding]
+		}
+		c.Writer = cw
+		defer func() {
+			cw.close()
+			c.Writer = cw.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+func acceptedEncoding(header string) string {
+	var deflate bool
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(fields) > 1 && strings.ReplaceAll(strings.TrimSpace(fields[1]), " ", "") == "q=0" {
+			continue
+		}
+		if name == "gzip" || name == "*" {
+			return "gzip"
+		} else if name == "deflate" {
+			deflate = true
+		}
+	}
+	if deflate {
+		return "deflate"
+	}
+	return ""
+}
+
+type resettableWriter interface {
+	io.WriteCloser
+	Reset(io.Writer)
+	Flush() error
+}
+
+type compressWriter struct {
+	gin.ResponseWriter
+	opts     *CompressionOptions
+	encoding string
+	pool     *sync.Pool
+	buf      []byte
+	decided  bool
+	zw       resettableWriter
+}
+
+func (w *compressWriter) compressible() bool {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	switch status := w.Status(); {
+	case status < 200, status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(w.buf)
+		header.Set("Content-Type", contentType)
+	}
+	contentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, t := range w.opts.ContentTypes {
+		if contentType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(contentType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// decide picks between compressing and passing through, then writes the buffer.
+func (w *compressWriter) decide(large bool) error {
+	w.decided = true
+	if len(w.buf) == 0 && !large {
+		return nil
+	}
+	if w.compressible() {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if large && w.encoding != "" {
+			w.Header().Set("Content-Encoding", w.encoding)
+			w.Header().Del("Content-Length")
+			w.zw = w.pool.Get().(resettableWriter)
+			w.zw.Reset(w.ResponseWriter)
+		}
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.write(buf)
+	return err
+}
+
+func (w *compressWriter) write(data []byte) (int, error) {
+	if w.zw != nil {
+		return w.zw.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.decided {
+		return w.write(data)
+	}
+	w.buf = append(w.buf, data...)
+	if len(w.buf) >= w.opts.MinSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) Written() bool {
+	return len(w.buf) > 0 || w.ResponseWriter.Written()
+}
+
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.zw != nil {
+		w.zw.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.decided = true
+	return w.ResponseWriter.Hijack()
+}
+
+func (w *compressWriter) close() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.zw != nil {
+		w.zw.Close()
+		w.zw.Reset(io.Discard)
+		w.pool.Put(w.zw)
+		w.zw = nil
+	}
+}
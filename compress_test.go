@@ -0,0 +1,66 @@
+package niuhe
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+type compressTestReq struct{}
+
+type compressTestRsp struct {
+	Text string `json:"text"`
+}
+
+type CompressTest struct{}
+
+func (api *CompressTest) Big_GET(c *Context, req *compressTestReq, rsp *compressTestRsp) error {
+	c.SetSession("seen", "yes")
+	rsp.Text = strings.Repeat("niuhe ", 1000)
+	return nil
+}
+
+func (api *CompressTest) Small_GET(c *Context, req *compressTestReq, rsp *compressTestRsp) error {
+	rsp.Text = "tiny"
+	return nil
+}
+
+func TestCompression(t *testing.T) {
+	mod := NewModule("/api")
+	mod.Use(SessionMiddleware("sess", func() sessions.Store {
+		return sessions.NewCookieStore([]byte("0123456789abcdef0123456789abcdef"))
+	}))
+	mod.Register(&CompressTest{})
+	svr := NewServer()
+	svr.RegisterModule(mod)
+	svr.EnableCompression()
+	engine := svr.GetGinEngine()
+
+	w := doRequest(engine, http.MethodGet, "/api/compress_test/big/", map[string]string{"Accept-Encoding": "gzip, deflate"})
+	assertTrue(t, w.Header().Get("Content-Encoding") == "gzip", "large response should be gzipped")
+	assertTrue(t, w.Header().Get("Vary") == "Accept-Encoding", "Vary should be set")
+	assertTrue(t, strings.HasPrefix(w.Header().Get("Set-Cookie"), "sess="), "session cookie should survive compression, got %q", w.Header().Get("Set-Cookie"))
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(zr)
+	assertTrue(t, strings.Contains(string(body), `"result":0`), "gzipped body should decode to the envelope")
+
+	w = doRequest(engine, http.MethodGet, "/api/compress_test/small/", map[string]string{"Accept-Encoding": "gzip"})
+	assertTrue(t, w.Header().Get("Content-Encoding") == "", "small response should not be compressed")
+	assertTrue(t, strings.Contains(w.Body.String(), "tiny"), "small response should be intact, got %s", w.Body)
+
+	w = doRequest(engine, http.MethodGet, "/api/compress_test/big/", nil)
+	assertTrue(t, w.Header().Get("Content-Encoding") == "" && w.Body.Len() > 6000, "response should be plain without Accept-Encoding")
+}
+
+func TestAcceptedEncoding(t *testing.T) {
+	assertTrue(t, acceptedEncoding("deflate, gzip;q=0.8") == "gzip", "gzip should be preferred")
+	assertTrue(t, acceptedEncoding("gzip;q=0, deflate") == "deflate", "refused gzip should fall back to deflate")
+	assertTrue(t, acceptedEncoding("br") == "", "unsupported encodings should be ignored")
+}
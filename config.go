@@ -0,0 +1,323 @@
+package niuhe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration written as "30s" or "5m" in config files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+type LogConfig struct {
+	Level     string `json:"level" yaml:"level" toml:"level"`                // DEBUG, INFO, WARN, ERROR...
+	Output    string `json:"output" yaml:"output" toml:"output"`             // "stderr" (default), "stdout" or a file path
+	AccessLog string `json:"access_log" yaml:"access_log" toml:"access_log"` // "" keeps gin log lines, otherwise like Output
+}
+
+type SessionConfig struct {
+	Name     string   `json:"name" yaml:"name" toml:"name"`
+	Keys     []string `json:"keys" yaml:"keys" toml:"keys"` // authentication/encryption key pairs of the cookie store
+	MaxAge   int      `json:"max_age" yaml:"max_age" toml:"max_age"`
+	Domain   string   `json:"domain" yaml:"domain" toml:"domain"`
+	Path     string   `json:"path" yaml:"path" toml:"path"`
+	Secure   bool     `json:"secure" yaml:"secure" toml:"secure"`
+	HttpOnly bool     `json:"http_only" yaml:"http_only" toml:"http_only"`
+}
+
+type StaticConfig struct {
+	Path string `json:"path" yaml:"path" toml:"path"`
+	Root string `json:"root" yaml:"root" toml:"root"`
+}
+
+type TLSConfig struct {
+	CertFile     string `json:"cert_file" yaml:"cert_file" toml:"cert_file"`
+	KeyFile      string `json:"key_file" yaml:"key_file" toml:"key_file"`
+	ClientCAFile string `json:"client_ca_file" yaml:"client_ca_file" toml:"client_ca_file"`
+}
+
+// DBConfig holds the DSNs used by db.OpenEngines.
+type DBConfig struct {
+	Driver          string   `json:"driver" yaml:"driver" toml:"driver"` // defaults to mysql
+	Master          string   `json:"master" yaml:"master" toml:"master"`
+	Slaves          []string `json:"slaves" yaml:"slaves" toml:"slaves"`
+	MaxOpenConns    int      `json:"max_open_conns" yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int      `json:"max_idle_conns" yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime Duration `json:"conn_max_lifetime" yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ShowSQL         bool     `json:"show_sql" yaml:"show_sql" toml:"show_sql"`
+}
+
+type ServerConfig struct {
+	Listen          []string       `json:"listen" yaml:"listen" toml:"listen"`
+	PathPrefix      string         `json:"path_prefix" yaml:"path_prefix" toml:"path_prefix"`
+	ShutdownTimeout Duration       `json:"shutdown_timeout" yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	Log             LogConfig      `json:"log" yaml:"log" toml:"log"`
+	Session         *SessionConfig `json:"session" yaml:"session" toml:"session"`
+	Static          []StaticConfig `json:"static" yaml:"static" toml:"static"`
+	TLS             *TLSConfig     `json:"tls" yaml:"tls" toml:"tls"`
+	DB              DBConfig       `json:"db" yaml:"db" toml:"db"`
+}
+
+// LoadConfig reads a YAML, TOML or JSON file, chosen by its extension, into v
+// and then applies environment overrides. A field is overridden by the
+// variable made of envPrefix and the upper-cased json names on its path,
+// e.g. NIUHE_DB_MASTER or NIUHE_LOG_LEVEL; lists are comma separated.
+// Without envPrefix the environment is ignored, so that unrelated variables
+// like PATH or LISTEN are not taken for config fields.
+// v may embed ServerConfig in an application specific struct; give the
+// embedded field a `yaml:",inline"` tag for YAML files.
+func LoadConfig(path string, envPrefix string, v interface{}) error {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(data, v)
+		case ".toml":
+			err = toml.Unmarshal(data, v)
+		case ".json":
+			err = json.Unmarshal(data, v)
+		default:
+			err = fmt.Errorf("unknown config format %s", filepath.Ext(path))
+		}
+		if err != nil {
+			return fmt.Errorf("load config %s: %w", path, err)
+		}
+	}
+	if envPrefix == "" {
+		return nil
+	}
+	return applyEnvOverrides(reflect.ValueOf(v).Elem(), strings.ToUpper(envPrefix))
+}
+
+var textUnmarshalerType = reflect.TypeOf((*interface{ UnmarshalText([]byte) error })(nil)).Elem()
+
+func applyEnvOverrides(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		fv := v.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		envName := prefix
+		if field.Anonymous && name == "" {
+			// embedded structs share the prefix of their parent
+		} else {
+			if name == "" {
+				name = field.Name
+			}
+			if envName != "" {
+				envName += "_"
+			}
+			envName += strings.ToUpper(name)
+		}
+		if fv.Kind() == reflect.Struct && !reflect.PtrTo(fv.Type()).Implements(textUnmarshalerType) {
+			if err := applyEnvOverrides(fv, envName); err != nil {
+				return err
+			}
+			continue
+		}
+		if fv.Kind() == reflect.Ptr && fv.Type().Elem().Kind() == reflect.Struct {
+			if fv.IsNil() {
+				if !hasEnvWithPrefix(envName + "_") {
+					continue
+				}
+				fv.Set(reflect.New(fv.Type().Elem()))
+			}
+			if err := applyEnvOverrides(fv.Elem(), envName); err != nil {
+				return err
+			}
+			continue
+		}
+		value, exists := os.LookupEnv(envName)
+		if !exists {
+			continue
+		}
+		if err := setFromString(fv, value); err != nil {
+			return fmt.Errorf("env %s: %w", envName, err)
+		}
+	}
+	return nil
+}
+
+func hasEnvWithPrefix(prefix string) bool {
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func setFromString(fv reflect.Value, value string) error {
+	if u, ok := fv.Addr().Interface().(interface{ UnmarshalText([]byte) error }); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		parts := []string{}
+		if value != "" {
+			parts = strings.Split(value, ",")
+		}
+		slice := reflect.MakeSlice(fv.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setFromString(slice.Index(i), strings.TrimSpace(part)); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+	default:
+		return fmt.Errorf("unsupported kind %s", fv.Kind())
+	}
+	return nil
+}
+
+// openLogOutput opens the file closed on shutdown; detach is called before,
+// to stop writing to it.
+func openLogOutput(svr *Server, output string, detach func()) (*os.File, error) {
+	switch output {
+	case "", "stderr":
+		return os.Stderr, nil
+	case "stdout":
+		return os.Stdout, nil
+	}
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	svr.OnShutdown(func(ctx context.Context) error {
+		if detach != nil {
+			detach()
+		}
+		return f.Close()
+	})
+	return f, nil
+}
+
+// NewServerFromConfig builds a Server from cfg. Serve("") then listens on
+// every address of cfg.Listen. The database engines are opened separately
+// with db.OpenEngines(cfg.DB).
+func NewServerFromConfig(cfg *ServerConfig) (*Server, error) {
+	svr := NewServer()
+	svr.SetPathPrefix(cfg.PathPrefix)
+	if cfg.ShutdownTimeout > 0 {
+		svr.SetShutdownTimeout(time.Duration(cfg.ShutdownTimeout))
+	}
+	if cfg.Log.Level != "" {
+		level, ok := ParseLogLevelName(strings.ToUpper(cfg.Log.Level))
+		if !ok {
+			return nil, fmt.Errorf("unknown log level %s", cfg.Log.Level)
+		}
+		SetLogLevel(level)
+	}
+	if cfg.Log.Output != "" {
+		out, err := openLogOutput(svr, cfg.Log.Output, func() { SetLogOutput(os.Stderr) })
+		if err != nil {
+			return nil, err
+		}
+		SetLogOutput(out)
+	}
+	if cfg.Log.AccessLog != "" {
+		out, err := openLogOutput(svr, cfg.Log.AccessLog, nil)
+		if err != nil {
+			return nil, err
+		}
+		svr.SetAccessLog(AccessLogOptions{Sink: NewJSONAccessLogSink(out)})
+	}
+	if cfg.Session != nil {
+		if len(cfg.Session.Keys) == 0 {
+			return nil, fmt.Errorf("session keys are required")
+		}
+		sessCfg := *cfg.Session
+		if sessCfg.Name == "" {
+			sessCfg.Name = "session"
+		}
+		svr.UseNiuhe(SessionMiddleware(sessCfg.Name, func() sessions.Store {
+			keyPairs := make([][]byte, len(sessCfg.Keys))
+			for i, key := range sessCfg.Keys {
+				keyPairs[i] = []byte(key)
+			}
+			store := sessions.NewCookieStore(keyPairs...)
+			store.Options.Domain = sessCfg.Domain
+			store.Options.Secure = sessCfg.Secure
+			store.Options.HttpOnly = sessCfg.HttpOnly
+			if sessCfg.Path != "" {
+				store.Options.Path = sessCfg.Path
+			}
+			if sessCfg.MaxAge != 0 {
+				store.MaxAge(sessCfg.MaxAge)
+			}
+			return store
+		}))
+	}
+	for _, static := range cfg.Static {
+		svr.Static(static.Path, static.Root)
+	}
+	if cfg.TLS != nil {
+		svr.SetTLS(TLSOptions{
+			CertFile:     cfg.TLS.CertFile,
+			KeyFile:      cfg.TLS.KeyFile,
+			ClientCAFile: cfg.TLS.ClientCAFile,
+		})
+	}
+	for _, addr := range cfg.Listen {
+		svr.AddListener(addr, ListenOptions{})
+	}
+	return svr, nil
+}
@@ -0,0 +1,79 @@
+package niuhe
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "app.yaml")
+	os.WriteFile(yamlFile, []byte(`
+listen: ["127.0.0.1:8080", "unix:/tmp/app.sock"]
+path_prefix: /svc
+shutdown_timeout: 15s
+log:
+  level: warn
+db:
+  master: root@tcp(db:3306)/app
+  slaves: ["root@tcp(db1:3306)/app"]
+`), 0644)
+	t.Setenv("APP_DB_MASTER", "root@tcp(other:3306)/app")
+	t.Setenv("APP_SESSION_KEYS", "k1,k2")
+
+	var cfg struct {
+		ServerConfig `yaml:",inline"`
+		Feature      string `json:"feature"`
+	}
+	t.Setenv("APP_FEATURE", "on")
+	if err := LoadConfig(yamlFile, "APP", &cfg); err != nil {
+		t.Fatal(err)
+	}
+	assertTrue(t, len(cfg.Listen) == 2 && cfg.PathPrefix == "/svc", "yaml values should be loaded, got %+v", cfg.ServerConfig)
+	assertTrue(t, time.Duration(cfg.ShutdownTimeout) == 15*time.Second, "duration should be parsed, got %v", cfg.ShutdownTimeout)
+	assertTrue(t, cfg.DB.Master == "root@tcp(other:3306)/app", "env should override the master DSN, got %s", cfg.DB.Master)
+	assertTrue(t, cfg.Session != nil && len(cfg.Session.Keys) == 2, "env should create the session section")
+	assertTrue(t, cfg.Feature == "on", "application fields should be overridable")
+
+	tomlFile := filepath.Join(dir, "app.toml")
+	os.WriteFile(tomlFile, []byte("path_prefix = \"/t\"\n[log]\nlevel = \"info\"\n"), 0644)
+	t.Setenv("PATH_PREFIX", "/env")
+	var tomlCfg ServerConfig
+	if err := LoadConfig(tomlFile, "", &tomlCfg); err != nil {
+		t.Fatal(err)
+	}
+	assertTrue(t, tomlCfg.PathPrefix == "/t" && tomlCfg.Log.Level == "info", "toml values should be loaded, got %+v", tomlCfg)
+}
+
+func TestNewServerFromConfig(t *testing.T) {
+	level := LogLevel()
+	defer SetLogLevel(level)
+	svr, err := NewServerFromConfig(&ServerConfig{
+		Listen:     []string{"127.0.0.1:0"},
+		PathPrefix: "/svc",
+		Log:        LogConfig{Level: "error"},
+		Session:    &SessionConfig{Keys: []string{"0123456789abcdef"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTrue(t, svr.PathPrefix == "/svc/" && len(svr.listenSpecs) == 1, "server should be configured")
+	assertTrue(t, LogLevel() == LOG_ERROR && len(svr.niuheMiddlewares) == 1, "log level and session should be configured")
+}
+
+func TestConfigLogOutputShutdown(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	svr, err := NewServerFromConfig(&ServerConfig{Log: LogConfig{Output: logFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetLogOutput(os.Stderr)
+	assertTrue(t, defaultLogger.loggers[LOG_INFO].Writer() != os.Stderr, "log should be written to the file")
+	if err := svr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertTrue(t, defaultLogger.loggers[LOG_INFO].Writer() == os.Stderr, "log should be back on stderr before the file is closed")
+}
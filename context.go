@@ -1,16 +1,55 @@
 package niuhe
 
-import "github.com/gin-gonic/gin"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const contextKey = "niuhe.context"
 
 type Context struct {
 	*gin.Context
 	index    int8
 	handlers []HandlerFunc
 	sessCtrl _SessCtrl
+	route    *routeInfo
+	protocol IApiProtocol
+	reqType  reflect.Type
+	rspType  reflect.Type
+	reqValue reflect.Value
+	reqErr   error
+	reqRead  bool
+	apiErr   error
+	apiDone  bool
+	injected []injectTiming
+}
+
+type injectTiming struct {
+	t        reflect.Type
+	duration time.Duration
 }
 
 func newContext(c *gin.Context, middlewares []HandlerFunc) *Context {
-	return &Context{Context: c, index: -1, handlers: middlewares}
+	context := &Context{Context: c, index: -1, handlers: middlewares}
+	c.Set(contextKey, context)
+	return context
+}
+
+// GetContext returns the niuhe Context of a request handled by a Module route,
+// so that gin middlewares can inspect it after calling c.Next(). It returns
+// nil for other requests.
+func GetContext(c *gin.Context) *Context {
+	if v, exists := c.Get(contextKey); exists {
+		context, _ := v.(*Context)
+		return context
+	}
+	return nil
 }
 
 func (c *Context) Next() {
@@ -25,6 +64,151 @@ func (c *Context) Abort() {
 	c.index = abortIndex
 }
 
+// RoutePath returns the registered path (server and module prefixes
+// included) of the route being served, or "" when the request is not
+// handled by a Module. Metrics labels and the keys of the rate limit,
+// idempotency and response cache middlewares are built from it, so they
+// change along with Server.PathPrefix.
+func (c *Context) RoutePath() string {
+	if c.route == nil {
+		return ""
+	}
+	return c.route.fullPath()
+}
+
+// readRequest decodes the request struct with the route protocol on first
+// use, so middlewares and the handler share one decoded value.
+func (c *Context) readRequest() (reflect.Value, error) {
+	if !c.reqRead {
+		c.reqRead = true
+		c.reqValue = reflect.New(c.reqType)
+		c.reqErr = c.protocol.Read(c, c.reqValue)
+	}
+	return c.reqValue, c.reqErr
+}
+
+func (c *Context) setApiResult(err error) {
+	c.apiErr = err
+	c.apiDone = true
+}
+
+// ApiResult returns the result code and message written in the response
+// envelope. ok is false until the API (or AbortWithApiError) has responded.
+func (c *Context) ApiResult() (code int, message string, ok bool) {
+	if !c.apiDone {
+		return 0, "", false
+	}
+	code, message = resultOf(c.apiErr)
+	return code, message, true
+}
+
+// RouteNames returns the group type and method names of the handler serving
+// the request, e.g. "UserApi" and "Login_POST".
+func (c *Context) RouteNames() (group, method string) {
+	if c.route == nil {
+		return "", ""
+	}
+	return c.route.groupName, c.route.funcName
+}
+
+// AbortWithApiError writes err through the protocol of the current route and
+// stops the remaining handlers, so middlewares can reject a call the same way
+// the API itself would.
+func (c *Context) AbortWithApiError(err error) {
+	protocol := c.protocol
+	if protocol == nil {
+		protocol = GetDefaultProtocolFactory().GetProtocol()
+	}
+	var rsp reflect.Value
+	if c.rspType != nil {
+		rsp = reflect.New(c.rspType)
+	} else {
+		rsp = reflect.ValueOf(&struct{}{})
+	}
+	c.Abort()
+	c.writeApiResponse(protocol, rsp, err)
+}
+
+// WriteErrorHook observes responses the protocol failed to write, e.g.
+// because of a NaN float in the response.
+type WriteErrorHook func(c *Context, err error, rsp reflect.Value)
+
+// SetWriteErrorHook sets the hook of the routes of the module, overriding
+// the one of the server.
+func (mod *Module) SetWriteErrorHook(hook WriteErrorHook) *Module {
+	mod.writeErrorHook = hook
+	return mod
+}
+
+// SetWriteErrorHook sets the hook of the modules without their own.
+func (svr *Server) SetWriteErrorHook(hook WriteErrorHook) {
+	svr.writeErrorHook = hook
+}
+
+// describeWriteError names the value the JSON encoder choked on, which the
+// message of the error alone may not tell.
+func describeWriteError(err error) string {
+	var valueErr *json.UnsupportedValueError
+	var typeErr *json.UnsupportedTypeError
+	var marshalerErr *json.MarshalerError
+	switch {
+	case errors.As(err, &valueErr):
+		if !valueErr.Value.IsValid() { // left unset by some encoders
+			return "unsupported value " + valueErr.Str
+		}
+		return fmt.Sprintf("unsupported %s value %s", valueErr.Value.Type(), valueErr.Str)
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("unsupported type %s", typeErr.Type)
+	case errors.As(err, &marshalerErr):
+		return fmt.Sprintf("marshal %s: %v", marshalerErr.Type, marshalerErr.Unwrap())
+	}
+	return err.Error()
+}
+
+var errWriteResponse = errors.New("failed to write response")
+
+// statusWriter writes status whatever status the protocol asks for.
+type statusWriter struct {
+	gin.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(int) {
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// writeApiResponse writes the result of an API call with protocol. When that
+// fails, the failure is logged and a minimal error is written with status
+// 500 instead, by protocol again or as a JSON envelope if it fails too.
+func (c *Context) writeApiResponse(protocol IApiProtocol, rsp reflect.Value, err error) {
+	c.setApiResult(err)
+	werr := protocol.Write(c, rsp, err)
+	if werr == nil {
+		return
+	}
+	c.Logger().Error("write response of %s (%s) failed: %s", c.RoutePath(), rsp.Type(), describeWriteError(werr))
+	c.setApiResult(werr)
+	if c.route != nil && c.route.writeErrorHook != nil {
+		c.route.writeErrorHook(c, werr, rsp)
+	}
+	if jsonrpcCallOf(c.Context) != nil || c.Writer.Written() {
+		return
+	}
+	writer := c.Writer
+	c.Writer = &statusWriter{writer, http.StatusInternalServerError}
+	werr = protocol.Write(c, reflect.ValueOf(&struct{}{}), errWriteResponse)
+	c.Writer = writer
+	if werr == nil || c.Writer.Written() {
+		return
+	}
+	env := c.envelope()
+	data, _ := json.Marshal(map[string]interface{}{
+		env.ResultField:  -1,
+		env.MessageField: errWriteResponse.Error(),
+	})
+	c.Data(http.StatusInternalServerError, "application/json; charset=utf-8", data)
+}
+
 // Session segment
 
 func (c *Context) SetSession(key string, value interface{}) {
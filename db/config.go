@@ -0,0 +1,49 @@
+package db
+
+import (
+	"time"
+
+	"github.com/ziipin-server/niuhe"
+	"xorm.io/xorm"
+)
+
+// OpenEngines opens the master and slave engines described by cfg, to be used
+// with NewDBWithSlaves.
+func OpenEngines(cfg niuhe.DBConfig) (masterEngine *xorm.Engine, slaveEngines []*xorm.Engine, err error) {
+	driver := cfg.Driver
+	if driver == "" {
+		driver = "mysql"
+	}
+	open := func(dsn string) (*xorm.Engine, error) {
+		engine, err := xorm.NewEngine(driver, dsn)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.MaxOpenConns > 0 {
+			engine.SetMaxOpenConns(cfg.MaxOpenConns)
+		}
+		if cfg.MaxIdleConns > 0 {
+			engine.SetMaxIdleConns(cfg.MaxIdleConns)
+		}
+		if cfg.ConnMaxLifetime > 0 {
+			engine.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime))
+		}
+		engine.ShowSQL(cfg.ShowSQL)
+		return engine, nil
+	}
+	if masterEngine, err = open(cfg.Master); err != nil {
+		return nil, nil, err
+	}
+	for _, dsn := range cfg.Slaves {
+		engine, err := open(dsn)
+		if err != nil {
+			masterEngine.Close()
+			for _, slave := range slaveEngines {
+				slave.Close()
+			}
+			return nil, nil, err
+		}
+		slaveEngines = append(slaveEngines, engine)
+	}
+	return masterEngine, slaveEngines, nil
+}
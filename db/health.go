@@ -0,0 +1,24 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ziipin-server/niuhe"
+	"xorm.io/xorm"
+)
+
+// PingCheck returns a health check pinging the master and every slave engine.
+func PingCheck(masterEngine *xorm.Engine, slaveEngines ...*xorm.Engine) niuhe.HealthCheckFunc {
+	return func(ctx context.Context) error {
+		if err := masterEngine.PingContext(ctx); err != nil {
+			return fmt.Errorf("master: %w", err)
+		}
+		for i, engine := range slaveEngines {
+			if err := engine.PingContext(ctx); err != nil {
+				return fmt.Errorf("slave %d: %w", i, err)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/ziipin-server/niuhe"
+	"xorm.io/xorm"
+)
+
+const (
+	IdempotencyPending = 0
+	IdempotencyDone    = 1
+)
+
+// IdempotencyRecord is a row of the table of an IdempotencyStore.
+type IdempotencyRecord struct {
+	IdemKey  string `xorm:"varchar(64) pk"` // sha256 of the key
+	Status   int    `xorm:"notnull"`
+	Response []byte `xorm:"blob"`          // JSON encoded niuhe.StoredResponse
+	ExpireAt int64  `xorm:"notnull index"` // unix milliseconds
+}
+
+// IdempotencyStore is a niuhe.IdempotencyStore keeping reservations and
+// responses in a table, so retries are recognized by every instance.
+type IdempotencyStore struct {
+	engine *xorm.Engine
+	table  string
+}
+
+var _ niuhe.IdempotencyStore = (*IdempotencyStore)(nil)
+
+// NewIdempotencyStore stores keys in table, created by Sync if needed.
+func NewIdempotencyStore(engine *xorm.Engine, table string) *IdempotencyStore {
+	return &IdempotencyStore{engine: engine, table: table}
+}
+
+// Sync creates or updates the table.
+func (s *IdempotencyStore) Sync() error {
+	return s.engine.Table(s.table).Sync2(new(IdempotencyRecord))
+}
+
+func idempotencyKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+func (s *IdempotencyStore) Acquire(key string, ttl time.Duration) (*niuhe.StoredResponse, error) {
+	now := nowMillis()
+	record := &IdempotencyRecord{
+		IdemKey:  idempotencyKey(key),
+		Status:   IdempotencyPending,
+		ExpireAt: now + ttl.Milliseconds(),
+	}
+	_, insertErr := s.engine.Table(s.table).Insert(record)
+	if insertErr == nil {
+		return nil, nil
+	}
+	// the key exists unless the insert failed for another reason
+	var existing IdempotencyRecord
+	found, err := s.engine.Table(s.table).Where("idem_key = ?", record.IdemKey).Get(&existing)
+	if err != nil {
+		return nil, err
+	} else if !found {
+		return nil, insertErr
+	}
+	if existing.ExpireAt <= now {
+		// take the expired row over unless another request just did
+		affected, err := s.engine.Table(s.table).
+			Where("idem_key = ? AND expire_at = ?", record.IdemKey, existing.ExpireAt).
+			Cols("status", "response", "expire_at").
+			Update(record)
+		if err != nil {
+			return nil, err
+		} else if affected == 0 {
+			return nil, niuhe.ErrIdempotencyInProgress
+		}
+		return nil, nil
+	}
+	if existing.Status != IdempotencyDone {
+		return nil, niuhe.ErrIdempotencyInProgress
+	}
+	var rsp niuhe.StoredResponse
+	if err := json.Unmarshal(existing.Response, &rsp); err != nil {
+		return nil, err
+	}
+	return &rsp, nil
+}
+
+func (s *IdempotencyStore) Save(key string, rsp *niuhe.StoredResponse, ttl time.Duration) error {
+	data, err := json.Marshal(rsp)
+	if err != nil {
+		return err
+	}
+	_, err = s.engine.Table(s.table).
+		Where("idem_key = ?", idempotencyKey(key)).
+		Cols("status", "response", "expire_at").
+		Update(&IdempotencyRecord{Status: IdempotencyDone, Response: data, ExpireAt: nowMillis() + ttl.Milliseconds()})
+	return err
+}
+
+func (s *IdempotencyStore) Release(key string) error {
+	_, err := s.engine.Table(s.table).
+		Where("idem_key = ? AND status = ?", idempotencyKey(key), IdempotencyPending).
+		Delete(new(IdempotencyRecord))
+	return err
+}
+
+// Purge deletes expired rows, e.g. from a periodic job.
+func (s *IdempotencyStore) Purge() (int64, error) {
+	return s.engine.Table(s.table).Where("expire_at <= ?", nowMillis()).Delete(new(IdempotencyRecord))
+}
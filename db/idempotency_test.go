@@ -0,0 +1,70 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/ziipin-server/niuhe"
+	"xorm.io/xorm"
+)
+
+func newTestIdempotencyStore(t *testing.T) *IdempotencyStore {
+	engine, err := xorm.NewEngine("sqlite3", "file::memory:?cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.SetMaxOpenConns(1)
+	t.Cleanup(func() { engine.Close() })
+	store := NewIdempotencyStore(engine, "idempotency_keys")
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	store := newTestIdempotencyStore(t)
+
+	rsp, err := store.Acquire("k1", time.Minute)
+	if rsp != nil || err != nil {
+		t.Fatalf("first acquire should reserve the key, got %v %v", rsp, err)
+	}
+	if _, err := store.Acquire("k1", time.Minute); err != niuhe.ErrIdempotencyInProgress {
+		t.Fatalf("reserved key should be in progress, got %v", err)
+	}
+	saved := &niuhe.StoredResponse{Status: 200, ContentType: "application/json", Body: []byte(`{"result":0}`)}
+	if err := store.Save("k1", saved, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	rsp, err = store.Acquire("k1", time.Minute)
+	if err != nil || rsp == nil || string(rsp.Body) != `{"result":0}` || rsp.Status != 200 {
+		t.Fatalf("saved response should be returned, got %+v %v", rsp, err)
+	}
+
+	store.Acquire("k2", time.Minute)
+	if err := store.Release("k2"); err != nil {
+		t.Fatal(err)
+	}
+	if rsp, err := store.Acquire("k2", time.Minute); rsp != nil || err != nil {
+		t.Fatalf("released key should be reserved again, got %v %v", rsp, err)
+	}
+}
+
+func TestIdempotencyStoreExpiry(t *testing.T) {
+	store := newTestIdempotencyStore(t)
+
+	store.Acquire("k", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if rsp, err := store.Acquire("k", time.Minute); rsp != nil || err != nil {
+		t.Fatalf("expired key should be taken over, got %v %v", rsp, err)
+	}
+	if _, err := store.Acquire("k", time.Minute); err != niuhe.ErrIdempotencyInProgress {
+		t.Fatalf("taken over key should be in progress, got %v", err)
+	}
+	store.Save("k", &niuhe.StoredResponse{Status: 200}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if n, err := store.Purge(); n != 1 || err != nil {
+		t.Fatalf("expired row should be purged, got %d %v", n, err)
+	}
+}
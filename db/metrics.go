@@ -0,0 +1,40 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"xorm.io/xorm/contexts"
+)
+
+type timingHook struct {
+	observe func(kind string, duration time.Duration)
+}
+
+// NewTimingHook returns a xorm hook reporting the duration of every statement
+// with its SQL verb, e.g. engine.AddHook(db.NewTimingHook(metrics.ObserveDB)).
+func NewTimingHook(observe func(kind string, duration time.Duration)) contexts.Hook {
+	return &timingHook{observe: observe}
+}
+
+func (h *timingHook) BeforeProcess(c *contexts.ContextHook) (context.Context, error) {
+	return c.Ctx, nil
+}
+
+func (h *timingHook) AfterProcess(c *contexts.ContextHook) error {
+	h.observe(sqlKind(c.SQL), c.ExecuteTime)
+	return nil
+}
+
+func sqlKind(sql string) string {
+	sql = strings.TrimSpace(sql)
+	if idx := strings.IndexAny(sql, " \t\r\n("); idx > 0 {
+		sql = sql[:idx]
+	}
+	switch kind := strings.ToUpper(sql); kind {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "REPLACE", "BEGIN", "COMMIT", "ROLLBACK", "SAVEPOINT", "RELEASE":
+		return kind
+	}
+	return "OTHER"
+}
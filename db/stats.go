@@ -0,0 +1,20 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"xorm.io/xorm"
+)
+
+// PoolStats returns a function reporting the connection pool statistics of
+// the master and slave engines, for niuhe.AdminOptions.DBStats.
+func PoolStats(masterEngine *xorm.Engine, slaveEngines ...*xorm.Engine) func() map[string]sql.DBStats {
+	return func() map[string]sql.DBStats {
+		stats := map[string]sql.DBStats{"master": masterEngine.DB().Stats()}
+		for i, engine := range slaveEngines {
+			stats[fmt.Sprintf("slave%d", i)] = engine.DB().Stats()
+		}
+		return stats
+	}
+}
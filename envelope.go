@@ -0,0 +1,94 @@
+package niuhe
+
+import (
+	"reflect"
+	"time"
+)
+
+// EnvelopeConfig shapes the envelope written by DefaultApiProtocol and the
+// other API protocols, see Module.SetEnvelope.
+type EnvelopeConfig struct {
+	ResultField  string // defaults to "result"
+	MessageField string // defaults to "message"
+	DataField    string // defaults to "data"
+	// AlwaysData includes the response for errors too; by default it is only
+	// included when the result code is 0.
+	AlwaysData bool
+	// Extra fields computed for every response, see EnvelopeRequestID and
+	// EnvelopeServerTime. Fields whose value is nil are left out.
+	Extra map[string]func(c *Context, err error) interface{}
+}
+
+var defaultEnvelope = EnvelopeConfig{
+	ResultField:  "result",
+	MessageField: "message",
+	DataField:    "data",
+}
+
+// EnvelopeRequestID returns the request ID set by RequestIDMiddleware.
+func EnvelopeRequestID(c *Context, err error) interface{} {
+	if id := c.RequestID(); id != "" {
+		return id
+	}
+	return nil
+}
+
+// EnvelopeServerTime returns the current unix time in milliseconds.
+func EnvelopeServerTime(c *Context, err error) interface{} {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// SetEnvelope changes the envelope of the routes of the module, e.g. to
+// rename fields or add a server_time:
+//
+//	mod.SetEnvelope(niuhe.EnvelopeConfig{
+//		Extra: map[string]func(*niuhe.Context, error) interface{}{
+//			"server_time": niuhe.EnvelopeServerTime,
+//		},
+//	})
+func (mod *Module) SetEnvelope(cfg EnvelopeConfig) *Module {
+	if cfg.ResultField == "" {
+		cfg.ResultField = defaultEnvelope.ResultField
+	}
+	if cfg.MessageField == "" {
+		cfg.MessageField = defaultEnvelope.MessageField
+	}
+	if cfg.DataField == "" {
+		cfg.DataField = defaultEnvelope.DataField
+	}
+	mod.envelope = &cfg
+	return mod
+}
+
+func (c *Context) envelope() *EnvelopeConfig {
+	if c.route != nil && c.route.envelope != nil {
+		return c.route.envelope
+	}
+	return &defaultEnvelope
+}
+
+// buildEnvelope returns what API protocols serialize: the response itself
+// for custom roots, otherwise a map shaped by the envelope of the route.
+func buildEnvelope(c *Context, rsp reflect.Value, err error) interface{} {
+	rspInst := rsp.Interface()
+	if _, ok := rspInst.(isCustomRoot); ok {
+		return rspInst
+	}
+	env := c.envelope()
+	code, message := resultOf(err)
+	response := map[string]interface{}{
+		env.ResultField: code,
+	}
+	if err != nil {
+		response[env.MessageField] = message
+	}
+	if code == 0 || env.AlwaysData {
+		response[env.DataField] = rspInst
+	}
+	for name, fn := range env.Extra {
+		if v := fn(c, err); v != nil {
+			response[name] = v
+		}
+	}
+	return response
+}
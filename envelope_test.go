@@ -0,0 +1,43 @@
+package niuhe
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestModuleEnvelope(t *testing.T) {
+	svr := NewServer()
+	svr.RegisterModule(NewModule("/v1").Register(&RpcTest{}))
+	svr.RegisterModule(NewModule("/v2").Register(&RpcTest{}).SetEnvelope(EnvelopeConfig{
+		ResultField: "code",
+		DataField:   "payload",
+		AlwaysData:  true,
+		Extra: map[string]func(*Context, error) interface{}{
+			"server_time": EnvelopeServerTime,
+			"trace":       func(c *Context, err error) interface{} { return nil },
+		},
+	}))
+	engine := svr.GetGinEngine()
+
+	get := func(path string) map[string]interface{} {
+		w := doRequest(engine, http.MethodGet, path, nil)
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err, w.Body.String())
+		}
+		return body
+	}
+
+	v1 := get("/v1/rpc_test/add/?a=1&b=-1")
+	_, hasData := v1["data"]
+	assertTrue(t, v1["result"] == float64(7) && v1["message"] == "negative b" && !hasData, "default envelope changed: %v", v1)
+
+	v2 := get("/v2/rpc_test/add/?a=1&b=-1")
+	_, hasTrace := v2["trace"]
+	assertTrue(t, v2["code"] == float64(7) && v2["message"] == "negative b", "fields should be renamed: %v", v2)
+	assertTrue(t, v2["payload"] != nil && v2["server_time"] != nil && !hasTrace, "data and extra fields expected: %v", v2)
+	v2 = get("/v2/rpc_test/add/?a=1&b=2")
+	_, hasMessage := v2["message"]
+	assertTrue(t, v2["code"] == float64(0) && v2["payload"].(map[string]interface{})["sum"] == float64(3) && !hasMessage, "unexpected success envelope: %v", v2)
+}
@@ -44,3 +44,23 @@ func (err *CommError) Error() string {
 func NewNotice(message string) *CommError {
 	return NewCommError(0, message)
 }
+
+// resultOf maps an API error to the result code and message of the envelope.
+func resultOf(err error) (int, string) {
+	if err == nil {
+		return 0, ""
+	}
+	if commErr, ok := err.(ICommError); ok {
+		return commErr.GetCode(), commErr.GetMessage()
+	}
+	return -1, err.Error()
+}
+
+var errorStatuses = map[int]int{}
+
+// RegisterErrorStatus sets the HTTP status written by RestApiProtocolFactory
+// for CommErrors of code, e.g. RegisterErrorStatus(ErrCodeNotLogin, 401).
+// It must be called during initialization.
+func RegisterErrorStatus(code int, status int) {
+	errorStatuses[code] = status
+}
@@ -0,0 +1,165 @@
+package niuhe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const DefaultHealthCheckTimeout = 2 * time.Second
+
+type HealthCheckFunc func(ctx context.Context) error
+
+type HealthCheckOptions struct {
+	Timeout  time.Duration // defaults to DefaultHealthCheckTimeout
+	CacheTTL time.Duration // reuse the last result for this long, 0 runs the check on every probe
+	// Liveness makes the check part of the liveness probe as well. Other
+	// checks only affect readiness.
+	Liveness bool
+}
+
+type healthCheck struct {
+	name    string
+	check   HealthCheckFunc
+	opts    HealthCheckOptions
+	lock    sync.Mutex
+	lastErr error
+	lastAt  time.Time
+	lastDur time.Duration
+}
+
+type HealthCheckResult struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type HealthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks,omitempty"`
+}
+
+type healthState struct {
+	livePath  string
+	readyPath string
+	checks    []*healthCheck
+}
+
+func (svr *Server) healthState() *healthState {
+	if svr.health == nil {
+		svr.health = &healthState{livePath: "/healthz", readyPath: "/readyz"}
+	}
+	return svr.health
+}
+
+// AddHealthCheck registers a check reported by the health endpoints, which
+// default to /healthz (liveness) and /readyz (readiness).
+func (svr *Server) AddHealthCheck(name string, check HealthCheckFunc, opts ...HealthCheckOptions) {
+	hc := &healthCheck{name: name, check: check}
+	if len(opts) > 0 {
+		hc.opts = opts[0]
+	}
+	if hc.opts.Timeout <= 0 {
+		hc.opts.Timeout = DefaultHealthCheckTimeout
+	}
+	state := svr.healthState()
+	state.checks = append(state.checks, hc)
+}
+
+// SetHealthPaths enables the health endpoints on the given paths; an empty
+// path disables the corresponding endpoint.
+func (svr *Server) SetHealthPaths(livePath, readyPath string) {
+	state := svr.healthState()
+	state.livePath, state.readyPath = livePath, readyPath
+}
+
+func (hc *healthCheck) run(ctx context.Context) HealthCheckResult {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	if hc.lastAt.IsZero() || hc.opts.CacheTTL <= 0 || time.Since(hc.lastAt) > hc.opts.CacheTTL {
+		ctx, cancel := context.WithTimeout(ctx, hc.opts.Timeout)
+		defer cancel()
+		start := time.Now()
+		errCh := make(chan error, 1)
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					errCh <- fmt.Errorf("panic: %v", r)
+				}
+			}()
+			errCh <- hc.check(ctx)
+		}()
+		select {
+		case hc.lastErr = <-errCh:
+		case <-ctx.Done():
+			hc.lastErr = ctx.Err()
+		}
+		hc.lastAt = time.Now()
+		hc.lastDur = hc.lastAt.Sub(start)
+	}
+	result := HealthCheckResult{Status: "ok", DurationMs: hc.lastDur.Milliseconds()}
+	if hc.lastErr != nil {
+		result.Status = "fail"
+		result.Error = hc.lastErr.Error()
+	}
+	return result
+}
+
+// CheckHealth runs the readiness checks, or only the liveness ones when
+// liveness is true, concurrently.
+func (svr *Server) CheckHealth(ctx context.Context, liveness bool) HealthReport {
+	report := HealthReport{Status: "ok", Checks: map[string]HealthCheckResult{}}
+	if svr.health == nil {
+		return report
+	}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, hc := range svr.health.checks {
+		if liveness && !hc.opts.Liveness {
+			continue
+		}
+		wg.Add(1)
+		go func(hc *healthCheck) {
+			defer wg.Done()
+			result := hc.run(ctx)
+			lock.Lock()
+			defer lock.Unlock()
+			report.Checks[hc.name] = result
+			if result.Status != "ok" {
+				report.Status = "fail"
+			}
+		}(hc)
+	}
+	wg.Wait()
+	if !liveness && svr.ShuttingDown() {
+		report.Status = "shutting_down"
+	}
+	return report
+}
+
+func (svr *Server) healthHandler(liveness bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := svr.CheckHealth(c.Request.Context(), liveness)
+		status := http.StatusOK
+		if report.Status != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, report)
+	}
+}
+
+func (svr *Server) registerHealth(engine *gin.Engine) {
+	if svr.health == nil {
+		return
+	}
+	if svr.health.livePath != "" {
+		engine.GET(svr.health.livePath, svr.healthHandler(true))
+	}
+	if svr.health.readyPath != "" {
+		engine.GET(svr.health.readyPath, svr.healthHandler(false))
+	}
+}
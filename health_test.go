@@ -0,0 +1,40 @@
+package niuhe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	svr := NewServer()
+	svr.AddHealthCheck("alive", func(context.Context) error { return nil }, HealthCheckOptions{Liveness: true})
+	svr.AddHealthCheck("db", func(context.Context) error { return errors.New("down") })
+	svr.AddHealthCheck("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}, HealthCheckOptions{Timeout: 10 * time.Millisecond})
+	engine := svr.GetGinEngine()
+
+	live := doRequest(engine, http.MethodGet, "/healthz", nil)
+	assertTrue(t, live.Code == http.StatusOK, "liveness should pass, got %d %s", live.Code, live.Body)
+
+	ready := doRequest(engine, http.MethodGet, "/readyz", nil)
+	var report HealthReport
+	json.Unmarshal(ready.Body.Bytes(), &report)
+	assertTrue(t, ready.Code == http.StatusServiceUnavailable, "readiness should fail, got %d", ready.Code)
+	assertTrue(t, report.Checks["db"].Error == "down", "db check error should be reported, got %+v", report.Checks["db"])
+	assertTrue(t, report.Checks["slow"].Status == "fail", "slow check should time out, got %+v", report.Checks["slow"])
+}
+
+func TestReadinessDuringShutdown(t *testing.T) {
+	svr := NewServer()
+	svr.SetHealthPaths("/healthz", "/readyz")
+	engine := svr.GetGinEngine()
+	assertTrue(t, doRequest(engine, http.MethodGet, "/readyz", nil).Code == http.StatusOK, "should be ready before shutdown")
+	svr.Shutdown(context.Background())
+	assertTrue(t, doRequest(engine, http.MethodGet, "/readyz", nil).Code == http.StatusServiceUnavailable, "should not be ready during shutdown")
+}
@@ -0,0 +1,75 @@
+package niuhe
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Fixtures shared by the tests of several features.
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func doRequest(handler http.Handler, method, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+type idemTestReq struct{}
+
+type idemTestRsp struct {
+	N int `json:"n"`
+}
+
+type IdemTest struct {
+	n int
+}
+
+func (api *IdemTest) Create_POST(c *Context, req *idemTestReq, rsp *idemTestRsp) error {
+	api.n++
+	rsp.N = api.n
+	return nil
+}
+
+type vhostTestRsp struct {
+	Name string `json:"name"`
+}
+
+type VhostTest struct {
+	name string
+}
+
+func (api *VhostTest) Name_GET(c *Context, req *struct{}, rsp *vhostTestRsp) error {
+	rsp.Name = api.name
+	return nil
+}
+
+type rpcTestReq struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+type rpcTestRsp struct {
+	Sum int `json:"sum"`
+}
+
+type RpcTest struct{}
+
+func (api *RpcTest) Add(c *Context, req *rpcTestReq, rsp *rpcTestRsp) error {
+	if req.B < 0 {
+		return NewCommError(7, "negative b")
+	}
+	rsp.Sum = req.A + req.B
+	return nil
+}
@@ -0,0 +1,199 @@
+package niuhe
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrIdempotencyInProgress = errors.New("idempotency key is in progress")
+
+// IdempotencyStore keeps the responses of requests carrying an idempotency key.
+type IdempotencyStore interface {
+	// Acquire reserves key for a new request. It returns the stored response
+	// if the key has already completed, or ErrIdempotencyInProgress if another
+	// request currently holds it.
+	Acquire(key string, ttl time.Duration) (*StoredResponse, error)
+	// Save stores the response of the request holding key.
+	Save(key string, rsp *StoredResponse, ttl time.Duration) error
+	// Release drops the reservation of a request that produced no reusable response.
+	Release(key string) error
+}
+
+type memoryIdempotencyEntry struct {
+	rsp      *StoredResponse
+	expireAt time.Time
+}
+
+type MemoryIdempotencyStore struct {
+	lock      sync.Mutex
+	entries   map[string]*memoryIdempotencyEntry
+	lastSweep time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		entries:   make(map[string]*memoryIdempotencyEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryIdempotencyStore) sweep(now time.Time, ttl time.Duration) {
+	if now.Sub(s.lastSweep) < ttl {
+		return
+	}
+	for key, entry := range s.entries {
+		if now.After(entry.expireAt) {
+			delete(s.entries, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+func (s *MemoryIdempotencyStore) Acquire(key string, ttl time.Duration) (*StoredResponse, error) {
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sweep(now, ttl)
+	if entry, exists := s.entries[key]; exists && now.Before(entry.expireAt) {
+		if entry.rsp == nil {
+			return nil, ErrIdempotencyInProgress
+		}
+		return entry.rsp, nil
+	}
+	s.entries[key] = &memoryIdempotencyEntry{expireAt: now.Add(ttl)}
+	return nil, nil
+}
+
+func (s *MemoryIdempotencyStore) Save(key string, rsp *StoredResponse, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entries[key] = &memoryIdempotencyEntry{rsp: rsp, expireAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+type IdempotencyOptions struct {
+	Store  IdempotencyStore // defaults to a MemoryIdempotencyStore, see db.IdempotencyStore for a shared one
+	TTL    time.Duration    // defaults to 24 hours
+	Header string           // defaults to "Idempotency-Key"
+	// Scope optionally narrows keys, e.g. to the session user, so that clients
+	// cannot replay each other's responses.
+	Scope func(*Context) string
+	// ConflictError is returned through the route protocol while a request
+	// with the same key is still running.
+	ConflictError error
+	// MismatchError is returned through the route protocol when a key is
+	// reused with another query or body.
+	MismatchError error
+}
+
+// requestHash fingerprints the query and body of the request, leaving the
+// body readable for the handler.
+func requestHash(c *Context) (string, error) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return "", err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	h := sha256.New()
+	h.Write([]byte(c.Request.URL.RawQuery))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// IdempotencyMiddleware replays the first successful response of a POST
+// request for retries carrying the same idempotency key and payload within
+// TTL. Failed calls, with a result code other than 0 or a status of 500 and
+// above, release the key so that they can be retried.
+func IdempotencyMiddleware(opts IdempotencyOptions) HandlerFunc {
+	if opts.Store == nil {
+		opts.Store = NewMemoryIdempotencyStore()
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 24 * time.Hour
+	}
+	if opts.Header == "" {
+		opts.Header = "Idempotency-Key"
+	}
+	if opts.ConflictError == nil {
+		opts.ConflictError = NewCommError(-1, "request with the same idempotency key is in progress")
+	}
+	if opts.MismatchError == nil {
+		opts.MismatchError = NewCommError(-1, "idempotency key reused with another request")
+	}
+	return func(c *Context) {
+		idemKey := c.GetHeader(opts.Header)
+		// the key belongs to the whole request, not to each JSON-RPC call
+		if c.Request.Method != http.MethodPost || idemKey == "" || jsonrpcCallOf(c.Context) != nil {
+			c.Next()
+			return
+		}
+		key := c.RoutePath() + "\x00" + idemKey
+		if opts.Scope != nil {
+			key = opts.Scope(c) + "\x00" + key
+		}
+		hash, err := requestHash(c)
+		if err != nil {
+			c.AbortWithApiError(err)
+			return
+		}
+		stored, err := opts.Store.Acquire(key, opts.TTL)
+		if err == ErrIdempotencyInProgress {
+			c.AbortWithApiError(opts.ConflictError)
+			return
+		} else if err != nil {
+			c.Logger().Error("idempotency store acquire %s failed: %v", c.RoutePath(), err)
+			c.AbortWithApiError(err)
+			return
+		}
+		if stored != nil {
+			if stored.RequestHash != hash {
+				c.AbortWithApiError(opts.MismatchError)
+				return
+			}
+			c.Header("Idempotent-Replayed", "true")
+			stored.replay(c)
+			c.Abort()
+			return
+		}
+		writer := c.Writer
+		recorder := newResponseRecorder(writer)
+		c.Writer = recorder
+		saved := false
+		defer func() {
+			c.Writer = writer
+			if !saved {
+				if err := opts.Store.Release(key); err != nil {
+					c.Logger().Error("idempotency store release %s failed: %v", c.RoutePath(), err)
+				}
+			}
+		}()
+		c.Next()
+		succeeded := recorder.Status() < http.StatusInternalServerError && recorder.body.Len() > 0
+		if code, _, ok := c.ApiResult(); ok && code != 0 {
+			succeeded = false // API errors are written with status 200
+		}
+		if succeeded {
+			stored := recorder.stored()
+			stored.RequestHash = hash
+			if err := opts.Store.Save(key, stored, opts.TTL); err != nil {
+				c.Logger().Error("idempotency store save %s failed: %v", c.RoutePath(), err)
+			} else {
+				saved = true
+			}
+		}
+	}
+}
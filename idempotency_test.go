@@ -0,0 +1,92 @@
+package niuhe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyReplay(t *testing.T) {
+	api := &IdemTest{}
+	mod := NewModule("/api")
+	mod.Register(api, IdempotencyMiddleware(IdempotencyOptions{}))
+	svr := NewServer()
+	svr.RegisterModule(mod)
+	engine := svr.GetGinEngine()
+
+	headers := map[string]string{"Idempotency-Key": "k1"}
+	first := doRequest(engine, http.MethodPost, "/api/idem_test/create/", headers)
+	second := doRequest(engine, http.MethodPost, "/api/idem_test/create/", headers)
+	assertTrue(t, api.n == 1, "handler should run once, ran %d times", api.n)
+	assertTrue(t, first.Body.String() == second.Body.String(), "replayed body differs: %s vs %s", first.Body, second.Body)
+	assertTrue(t, second.Header().Get("Idempotent-Replayed") == "true", "replayed response should be marked")
+
+	doRequest(engine, http.MethodPost, "/api/idem_test/create/", map[string]string{"Idempotency-Key": "k2"})
+	doRequest(engine, http.MethodPost, "/api/idem_test/create/", nil)
+	assertTrue(t, api.n == 3, "handler should run for new or missing keys, ran %d times", api.n)
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	mod := NewModule("/api")
+	mod.Register(&IdemTest{}, IdempotencyMiddleware(IdempotencyOptions{Store: store}))
+	svr := NewServer()
+	svr.RegisterModule(mod)
+
+	if _, err := store.Acquire("/api/idem_test/create/\x00busy", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	w := doRequest(svr.GetGinEngine(), http.MethodPost, "/api/idem_test/create/", map[string]string{"Idempotency-Key": "busy"})
+	assertTrue(t, strings.Contains(w.Body.String(), `"result":-1`), "concurrent duplicate should be rejected, got %s", w.Body)
+}
+
+type IdemFlakyTest struct {
+	n int
+}
+
+func (api *IdemFlakyTest) Create_POST(c *Context, req *idemTestReq, rsp *idemTestRsp) error {
+	api.n++
+	if api.n == 1 {
+		return NewCommError(-1, "db timeout")
+	}
+	rsp.N = api.n
+	return nil
+}
+
+func TestIdempotencyRetriesFailures(t *testing.T) {
+	api := &IdemFlakyTest{}
+	svr := NewServer()
+	svr.RegisterModule(NewModule("/api").Register(api, IdempotencyMiddleware(IdempotencyOptions{})))
+	engine := svr.GetGinEngine()
+
+	headers := map[string]string{"Idempotency-Key": "k1"}
+	first := doRequest(engine, http.MethodPost, "/api/idem_flaky_test/create/", headers)
+	assertTrue(t, strings.Contains(first.Body.String(), "db timeout"), "first call should fail, got %s", first.Body)
+	second := doRequest(engine, http.MethodPost, "/api/idem_flaky_test/create/", headers)
+	assertTrue(t, api.n == 2 && strings.Contains(second.Body.String(), `"n":2`), "failure should not be replayed, got %s", second.Body)
+	third := doRequest(engine, http.MethodPost, "/api/idem_flaky_test/create/", headers)
+	assertTrue(t, api.n == 2 && third.Body.String() == second.Body.String(), "success should be replayed, got %s", third.Body)
+}
+
+func TestIdempotencyPayloadMismatch(t *testing.T) {
+	api := &IdemTest{}
+	svr := NewServer()
+	svr.RegisterModule(NewModule("/api").Register(api, IdempotencyMiddleware(IdempotencyOptions{})))
+	engine := svr.GetGinEngine()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/idem_test/create/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Idempotency-Key", "k1")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+	post("amount=1")
+	w := post("amount=1")
+	assertTrue(t, api.n == 1 && w.Header().Get("Idempotent-Replayed") == "true", "same payload should be replayed, got %s", w.Body)
+	w = post("amount=2")
+	assertTrue(t, api.n == 1 && strings.Contains(w.Body.String(), "reused with another request"), "other payload should be rejected, got %s", w.Body)
+}
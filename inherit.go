@@ -0,0 +1,278 @@
+//go:build !windows
+
+package niuhe
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	listenFdsStart  = 3
+	envListenFds    = "NIUHE_LISTEN_FDS"
+	envListenAddrs  = "NIUHE_LISTEN_ADDRS"
+	envReadyFd      = "NIUHE_READY_FD"
+	envSdListenPid  = "LISTEN_PID"
+	envSdListenFds  = "LISTEN_FDS"
+	envSdListenName = "LISTEN_FDNAMES"
+)
+
+type inheritedFile struct {
+	file      *os.File
+	name      string // address for niuhe handoffs, LISTEN_FDNAMES entry for systemd
+	ln        net.Listener
+	bySystemd bool
+	used      bool
+}
+
+var (
+	inheritOnce  sync.Once
+	inheritLock  sync.Mutex
+	inheritFiles []*inheritedFile
+	readyFile    *os.File // written once serving, to let the parent shut down
+	readyOnce    sync.Once
+)
+
+// loadInheritedFiles collects the listening sockets passed by systemd socket
+// activation or by a parent niuhe process restarting itself.
+func loadInheritedFiles() {
+	var names []string
+	var count int
+	bySystemd := false
+	if n, err := strconv.Atoi(os.Getenv(envListenFds)); err == nil && n > 0 {
+		count = n
+		if err := json.Unmarshal([]byte(os.Getenv(envListenAddrs)), &names); err != nil {
+			LogError("invalid %s: %v", envListenAddrs, err)
+		}
+	} else if pid, _ := strconv.Atoi(os.Getenv(envSdListenPid)); pid == os.Getpid() {
+		if n, err := strconv.Atoi(os.Getenv(envSdListenFds)); err == nil && n > 0 {
+			count = n
+			bySystemd = true
+			if fdNames := os.Getenv(envSdListenName); fdNames != "" {
+				names = strings.Split(fdNames, ":")
+			}
+		}
+	}
+	if fd, err := strconv.Atoi(os.Getenv(envReadyFd)); err == nil && fd >= listenFdsStart+count {
+		syscall.CloseOnExec(fd)
+		readyFile = os.NewFile(uintptr(fd), "ready-pipe")
+	}
+	for _, env := range []string{envListenFds, envListenAddrs, envReadyFd, envSdListenPid, envSdListenFds, envSdListenName} {
+		os.Unsetenv(env)
+	}
+	for i := 0; i < count; i++ {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+		f := &inheritedFile{file: os.NewFile(uintptr(fd), "listener-"+strconv.Itoa(fd)), bySystemd: bySystemd}
+		if i < len(names) {
+			f.name = names[i]
+		}
+		ln, err := net.FileListener(f.file)
+		if err != nil {
+			LogError("inherited fd %d is not a listener: %v", fd, err)
+			continue
+		}
+		f.ln = ln
+		inheritFiles = append(inheritFiles, f)
+	}
+}
+
+// inheritedListener returns the inherited listener matching addr, if any.
+func inheritedListener(addr string) (net.Listener, bool) {
+	inheritOnce.Do(loadInheritedFiles)
+	inheritLock.Lock()
+	defer inheritLock.Unlock()
+	var found *inheritedFile
+	for _, f := range inheritFiles {
+		if !f.used && f.name == addr {
+			found = f
+			break
+		}
+	}
+	if found == nil {
+		for _, f := range inheritFiles {
+			if !f.used && listenerMatches(f.ln, addr) {
+				found = f
+				break
+			}
+		}
+	}
+	if found == nil {
+		return nil, false
+	}
+	found.used = true
+	found.file.Close()
+	if unixLn, ok := found.ln.(*net.UnixListener); ok {
+		path := strings.TrimPrefix(addr, "unix:")
+		unixLn.SetUnlinkOnClose(false)
+		info, _ := os.Lstat(path)
+		return &unixListener{UnixListener: unixLn, path: path, info: info, keepFile: found.bySystemd || info == nil}, true
+	}
+	return found.ln, true
+}
+
+func listenerMatches(ln net.Listener, addr string) bool {
+	if strings.HasPrefix(addr, "unix:") {
+		unixAddr, ok := ln.Addr().(*net.UnixAddr)
+		return ok && unixAddr.Name == addr[5:]
+	}
+	tcpAddr, ok := ln.Addr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	want, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil || want.Port != tcpAddr.Port {
+		return false
+	}
+	if want.IP == nil || want.IP.IsUnspecified() {
+		return tcpAddr.IP.IsUnspecified()
+	}
+	return want.IP.Equal(tcpAddr.IP)
+}
+
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+// notifyReady tells the parent restarting this process that the inherited
+// listeners are being served.
+func notifyReady() {
+	inheritOnce.Do(loadInheritedFiles)
+	readyOnce.Do(func() {
+		if readyFile == nil {
+			return
+		}
+		if _, err := readyFile.Write([]byte{1}); err != nil {
+			LogError("notify parent of readiness failed: %v", err)
+		}
+		readyFile.Close()
+	})
+}
+
+// waitReady waits for the byte written by notifyReady to ready. It fails if
+// the child exits first or timeout passes.
+func waitReady(ready *os.File, exited <-chan error, timeout time.Duration) error {
+	readCh := make(chan error, 1)
+	go func() {
+		var buf [1]byte
+		_, err := ready.Read(buf[:])
+		readCh <- err
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-readCh:
+		if err != nil {
+			return fmt.Errorf("niuhe: new process exited before serving")
+		}
+		return nil
+	case err := <-exited:
+		return fmt.Errorf("niuhe: new process exited before serving: %v", err)
+	case <-timer.C:
+		return fmt.Errorf("niuhe: new process not serving after %v", timeout)
+	}
+}
+
+// Restart starts a new instance of the current executable that inherits the
+// listening sockets, and shuts this one down gracefully once the new one is
+// serving them. Connections keep queueing on the shared sockets meanwhile,
+// so none are refused. If the new instance exits or is not serving within
+// the restart timeout, it is killed and this one keeps serving.
+func (svr *Server) Restart() error {
+	svr.lock.Lock()
+	listeners := append([]servedListener{}, svr.listeners...)
+	svr.lock.Unlock()
+	if len(listeners) == 0 {
+		return fmt.Errorf("niuhe: no listener to hand over")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	files := make([]*os.File, 0, len(listeners))
+	addrs := make([]string, 0, len(listeners))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, l := range listeners {
+		ln := l.ln
+		if unixLn, ok := ln.(*unixListener); ok {
+			ln = unixLn.UnixListener
+		}
+		fl, ok := ln.(fileListener)
+		if !ok {
+			return fmt.Errorf("niuhe: listener of %s cannot be handed over", l.addr)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		addrs = append(addrs, l.addr)
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+	bAddrs, _ := json.Marshal(addrs)
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(),
+		envListenFds+"="+strconv.Itoa(len(files)),
+		envListenAddrs+"="+string(bAddrs),
+		envReadyFd+"="+strconv.Itoa(listenFdsStart+len(files)),
+	)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	err = cmd.Start()
+	readyW.Close() // only the child may write, so reads fail once it exits
+	if err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	if err := waitReady(readyR, exited, svr.restartTimeout); err != nil {
+		cmd.Process.Kill()
+		return err
+	}
+	LogInfo("handed listeners %v over to pid %d", addrs, cmd.Process.Pid)
+	for _, l := range listeners {
+		if unixLn, ok := l.ln.(*unixListener); ok {
+			unixLn.keepFile = true // the socket file now belongs to the new process
+		}
+	}
+	go svr.shutdownWithTimeout()
+	return nil
+}
+
+// watchRestartSignal restarts the server on SIGUSR2 until stop is closed.
+func (svr *Server) watchRestartSignal(stop <-chan struct{}) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(sigCh)
+		for {
+			select {
+			case <-sigCh:
+				if err := svr.Restart(); err != nil {
+					LogError("restart failed, still serving: %v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
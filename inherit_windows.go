@@ -0,0 +1,18 @@
+package niuhe
+
+import (
+	"errors"
+	"net"
+)
+
+func inheritedListener(addr string) (net.Listener, bool) {
+	return nil, false
+}
+
+func notifyReady() {}
+
+func (svr *Server) Restart() error {
+	return errors.New("niuhe: restart is not supported on windows")
+}
+
+func (svr *Server) watchRestartSignal(stop <-chan struct{}) {}
@@ -0,0 +1,312 @@
+package niuhe
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+)
+
+const jsonrpcCallKey = "niuhe.jsonrpc_call"
+
+// EnableJSONRPC serves every API route of the module through a JSON-RPC 2.0
+// endpoint at path (relative to the module prefix). The method name of a
+// route is its path with "/" replaced by ".", e.g. "user_api.login" for
+// /user_api/login/. Params must be an object decoded and validated like a
+// JSON request body; a CommError returned by the handler or a middleware
+// becomes the error object with the same code and message, except notices
+// (code 0) which leave the result. Batches are run in order.
+//
+// Each call runs the middlewares of its route on a copy of the request
+// context; whatever they write besides the result is dropped, and the
+// session is saved once for the whole request. Metrics and access logs
+// see the endpoint itself as the route, and IdempotencyMiddleware leaves
+// the calls alone.
+func (mod *Module) EnableJSONRPC(path string) *Module {
+	mod.jsonrpcPath = path
+	return mod
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+func newJSONRPCResponse() *jsonrpcResponse {
+	return &jsonrpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null")}
+}
+
+// jsonrpcCall is the protocol of a route while it runs for a JSON-RPC call:
+// it reads the params and keeps the result instead of writing it.
+type jsonrpcCall struct {
+	params  json.RawMessage
+	readErr error
+	done    bool
+	result  json.RawMessage
+	err     *jsonrpcError
+	session *_SessCtrl // saved after the batch, see _SessCtrl.Save
+}
+
+// Read decodes the params like a JSON request body, zpform validators
+// included; missing params are an empty object.
+func (call *jsonrpcCall) Read(c *Context, reqValue reflect.Value) error {
+	params := call.params
+	if len(params) == 0 || string(params) == "null" {
+		params = json.RawMessage("{}")
+	}
+	err := decodeJSONRequest(params, reqValue)
+	if err != nil {
+		call.readErr = err
+	}
+	return err
+}
+
+func (call *jsonrpcCall) Write(c *Context, rsp reflect.Value, err error) error {
+	c.beforeOutput()
+	call.done = true
+	code, message := resultOf(err)
+	if err == call.readErr && err != nil {
+		call.err = &jsonrpcError{JSONRPCInvalidParams, message}
+	} else if code != 0 {
+		call.err = &jsonrpcError{code, message}
+	} else {
+		// notices have code 0 and are successful calls
+		result, encodeErr := json.Marshal(rsp.Interface())
+		if encodeErr != nil {
+			call.err = &jsonrpcError{JSONRPCInternalError, "failed to write response"}
+			return encodeErr
+		}
+		call.result = result
+	}
+	return nil
+}
+
+func jsonrpcCallOf(c *gin.Context) *jsonrpcCall {
+	v, _ := c.Get(jsonrpcCallKey)
+	call, _ := v.(*jsonrpcCall)
+	return call
+}
+
+// validJSONRPCID tells whether id is a string, a number or null.
+func validJSONRPCID(id json.RawMessage) bool {
+	var v interface{}
+	if json.Unmarshal(id, &v) != nil {
+		return false
+	}
+	switch v.(type) {
+	case string, float64, nil:
+		return true
+	}
+	return false
+}
+
+func jsonrpcMethodName(info *routeInfo) string {
+	return strings.ReplaceAll(strings.Trim(info.Path, "/"), "/", ".")
+}
+
+// jsonrpcWriter stands for the response while a call runs. It keeps its own
+// headers and drops the body, so middlewares cannot write into the batch.
+type jsonrpcWriter struct {
+	header http.Header
+	status int
+	size   int
+}
+
+func newJSONRPCWriter() *jsonrpcWriter {
+	return &jsonrpcWriter{header: http.Header{}, status: http.StatusOK, size: -1}
+}
+
+func (w *jsonrpcWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *jsonrpcWriter) WriteHeader(code int) {
+	if code > 0 && !w.Written() {
+		w.status = code
+	}
+}
+
+func (w *jsonrpcWriter) WriteHeaderNow() {
+	if !w.Written() {
+		w.size = 0
+	}
+}
+
+func (w *jsonrpcWriter) Write(data []byte) (int, error) {
+	w.WriteHeaderNow()
+	w.size += len(data)
+	return len(data), nil
+}
+
+func (w *jsonrpcWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *jsonrpcWriter) Status() int {
+	return w.status
+}
+
+func (w *jsonrpcWriter) Size() int {
+	return w.size
+}
+
+func (w *jsonrpcWriter) Written() bool {
+	return w.size != -1
+}
+
+func (w *jsonrpcWriter) Flush() {}
+
+func (w *jsonrpcWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, http.ErrNotSupported
+}
+
+func (w *jsonrpcWriter) CloseNotify() <-chan bool {
+	return nil
+}
+
+func (w *jsonrpcWriter) Pusher() http.Pusher {
+	return nil
+}
+
+// jsonrpcHandler must be built after the handlers of the routes.
+func (mod *Module) jsonrpcHandler(svrPrefix string) gin.HandlerFunc {
+	endpoint := &routeInfo{Methods: POST, Path: mod.jsonrpcPath, svrPrefix: svrPrefix, prefix: mod.urlPrefix}
+	methods := map[string]gin.HandlerFunc{}
+	for _, info := range mod.routers {
+		if info.funcValue.Type().NumIn() < 4 {
+			continue // web handlers have no request struct
+		}
+		name := jsonrpcMethodName(info)
+		if _, exists := methods[name]; !exists {
+			methods[name] = info.HandleFunc
+		}
+	}
+	invoke := func(c *gin.Context, raw json.RawMessage, pending *[]*_SessCtrl) *jsonrpcResponse {
+		rsp := newJSONRPCResponse()
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			rsp.Error = &jsonrpcError{JSONRPCInvalidRequest, "invalid request"}
+			return rsp
+		}
+		// invalid requests are answered even without an id, with "id":null
+		id, hasID := fields["id"]
+		if hasID {
+			if !validJSONRPCID(id) {
+				rsp.Error = &jsonrpcError{JSONRPCInvalidRequest, "invalid request"}
+				return rsp
+			}
+			rsp.ID = id
+		}
+		var version, method string
+		if json.Unmarshal(fields["jsonrpc"], &version) != nil || version != "2.0" ||
+			json.Unmarshal(fields["method"], &method) != nil || method == "" {
+			rsp.Error = &jsonrpcError{JSONRPCInvalidRequest, "invalid request"}
+			return rsp
+		}
+		params := bytes.TrimSpace(fields["params"])
+		if len(params) > 0 && params[0] != '{' && string(params) != "null" {
+			rsp.Error = &jsonrpcError{JSONRPCInvalidParams, "params must be an object"}
+			return rsp
+		}
+		if handler, exists := methods[method]; !exists {
+			rsp.Error = &jsonrpcError{JSONRPCMethodNotFound, "method not found: " + method}
+		} else {
+			call := &jsonrpcCall{params: params}
+			callCtx := c.Copy()
+			callCtx.Writer = newJSONRPCWriter()
+			callCtx.Set(jsonrpcCallKey, call)
+			handler(callCtx)
+			if call.session != nil {
+				*pending = append(*pending, call.session)
+			}
+			if !call.done {
+				rsp.Error = &jsonrpcError{JSONRPCInternalError, "no response"}
+			} else if call.err != nil {
+				rsp.Error = call.err
+			} else {
+				rsp.Result = call.result
+			}
+		}
+		if !hasID {
+			return nil // notification
+		}
+		return rsp
+	}
+	return func(c *gin.Context) {
+		context := newContext(c, nil)
+		context.route = endpoint
+		var pending []*_SessCtrl
+		reply := func(rsp interface{}) {
+			saved := map[*sessions.Session]bool{}
+			for _, sc := range pending {
+				if !saved[sc.Session] {
+					saved[sc.Session] = true
+					sc.MustSave(context)
+				}
+			}
+			if rsp == nil {
+				c.Status(http.StatusNoContent)
+			} else {
+				c.JSON(http.StatusOK, rsp)
+			}
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		body = bytes.TrimSpace(body)
+		if err != nil || !json.Valid(body) {
+			rsp := newJSONRPCResponse()
+			rsp.Error = &jsonrpcError{JSONRPCParseError, "parse error"}
+			reply(rsp)
+			return
+		}
+		if body[0] != '[' {
+			if rsp := invoke(c, body, &pending); rsp != nil {
+				reply(rsp)
+			} else {
+				reply(nil)
+			}
+			return
+		}
+		var batch []json.RawMessage
+		json.Unmarshal(body, &batch)
+		if len(batch) == 0 {
+			rsp := newJSONRPCResponse()
+			rsp.Error = &jsonrpcError{JSONRPCInvalidRequest, "empty batch"}
+			reply(rsp)
+			return
+		}
+		rsps := make([]*jsonrpcResponse, 0, len(batch))
+		for _, raw := range batch {
+			if rsp := invoke(c, raw, &pending); rsp != nil {
+				rsps = append(rsps, rsp)
+			}
+		}
+		if len(rsps) == 0 {
+			reply(nil)
+			return
+		}
+		reply(rsps)
+	}
+}
@@ -0,0 +1,115 @@
+package niuhe
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+)
+
+func TestJSONRPC(t *testing.T) {
+	svr := NewServer()
+	svr.RegisterModule(NewModule("/api").Register(&RpcTest{}).EnableJSONRPC("/rpc"))
+	engine := svr.GetGinEngine()
+
+	call := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/rpc", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	w := call(`{"jsonrpc":"2.0","method":"rpc_test.add","params":{"a":1,"b":2},"id":1}`)
+	assertTrue(t, w.Body.String() == `{"jsonrpc":"2.0","result":{"sum":3},"id":1}`, "unexpected response %s", w.Body)
+	w = call(`{"jsonrpc":"2.0","method":"rpc_test.add","params":{"a":1,"b":-2},"id":"x"}`)
+	assertTrue(t, w.Body.String() == `{"jsonrpc":"2.0","error":{"code":7,"message":"negative b"},"id":"x"}`, "unexpected error response %s", w.Body)
+	w = call(`{"jsonrpc":"2.0","method":"rpc_test.add","params":{"a":1}}`)
+	assertTrue(t, w.Code == http.StatusNoContent, "notifications should get no response, got %d %s", w.Code, w.Body)
+	w = call(`{bad json`)
+	assertTrue(t, strings.Contains(w.Body.String(), `"code":-32700`), "parse errors should be reported, got %s", w.Body)
+
+	w = call(`[
+		{"jsonrpc":"2.0","method":"rpc_test.add","params":{"a":2,"b":2},"id":1},
+		{"jsonrpc":"2.0","method":"rpc_test.missing","id":2},
+		{"jsonrpc":"2.0","method":"rpc_test.add","params":{"a":"x"},"id":3},
+		{"jsonrpc":"2.0","method":"rpc_test.add","params":{}}
+	]`)
+	var rsps []struct {
+		Result *rpcTestRsp     `json:"result"`
+		Error  *jsonrpcError   `json:"error"`
+		ID     json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &rsps); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	assertTrue(t, len(rsps) == 3, "batch should get 3 responses, got %s", w.Body)
+	assertTrue(t, rsps[0].Result != nil && rsps[0].Result.Sum == 4, "first call should succeed, got %s", w.Body)
+	assertTrue(t, rsps[1].Error != nil && rsps[1].Error.Code == JSONRPCMethodNotFound, "unknown method should be reported, got %s", w.Body)
+	assertTrue(t, rsps[2].Error != nil && rsps[2].Error.Code == JSONRPCInvalidParams, "bad params should be reported, got %s", w.Body)
+}
+
+func TestJSONRPCBatchIsolation(t *testing.T) {
+	writeJunk := func(c *Context) {
+		c.Writer.WriteString("junk")
+		c.SetSession("calls", 1)
+		c.Next()
+	}
+	svr := NewServer()
+	svr.RegisterModule(NewModule("/api").
+		Use(SessionMiddleware("sess", func() sessions.Store { return sessions.NewCookieStore([]byte("secret")) })).
+		Use(writeJunk).
+		Register(&RpcTest{}).
+		EnableJSONRPC("/rpc"))
+	var route string
+	svr.Use(func(c *gin.Context) {
+		c.Next()
+		if context := GetContext(c); context != nil {
+			route = context.RoutePath()
+		}
+	})
+	engine := svr.GetGinEngine()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/rpc", strings.NewReader(`[
+		{"jsonrpc":"2.0","method":"rpc_test.add","params":{"a":1,"b":2},"id":1},
+		{"jsonrpc":"2.0","method":"rpc_test.add","params":{"a":3,"b":4},"id":2}
+	]`))
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	body := w.Body.String()
+	assertTrue(t, body == `[{"jsonrpc":"2.0","result":{"sum":3},"id":1},{"jsonrpc":"2.0","result":{"sum":7},"id":2}]`, "middleware writes should not reach the batch, got %s", body)
+	assertTrue(t, len(w.Result().Cookies()) == 1, "session should be saved once, got %v", w.Header()["Set-Cookie"])
+	assertTrue(t, route == "/api/rpc", "endpoint should be the route of the request, got %q", route)
+}
+
+type rpcGreetReq struct {
+	Name string `json:"name" zpf_name:"name" zpf_reqd:"true"`
+}
+
+func (api *RpcTest) Greet(c *Context, req *rpcGreetReq, rsp *rpcTestRsp) error {
+	rsp.Sum = len(req.Name)
+	return NewNotice("greeted")
+}
+
+func TestJSONRPCEdgeCases(t *testing.T) {
+	svr := NewServer()
+	svr.RegisterModule(NewModule("/api").Register(&RpcTest{}).EnableJSONRPC("/rpc"))
+	engine := svr.GetGinEngine()
+
+	for body, expected := range map[string]string{
+		`{"jsonrpc":"2.0","method":"rpc_test.greet","params":{"name":"bob"},"id":1}`: `{"jsonrpc":"2.0","result":{"sum":3},"id":1}`,
+		`{"jsonrpc":"2.0","method":"rpc_test.greet","id":2}`:                         `{"jsonrpc":"2.0","error":{"code":-32602,"message":"Name（必填）"},"id":2}`,
+		`{"jsonrpc":"1.0","method":"rpc_test.add"}`:                                  `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":null}`,
+		`{"jsonrpc":"2.0","method":"rpc_test.add","params":[1,2]}`:                   `{"jsonrpc":"2.0","error":{"code":-32602,"message":"params must be an object"},"id":null}`,
+		`{"jsonrpc":"2.0","method":"rpc_test.add","id":{"a":1}}`:                     `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":null}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/rpc", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		assertTrue(t, w.Body.String() == expected, "%s should give %s, got %d %s", body, expected, w.Code, w.Body)
+	}
+}
@@ -0,0 +1,154 @@
+package niuhe
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type UnixSocketOptions struct {
+	Mode  os.FileMode // permission bits of the socket file, 0777 by default
+	User  string      // owner name or uid, "" keeps the current user
+	Group string      // group name or gid, "" keeps the current group
+}
+
+// SetUnixSocketOptions configures the socket files created for "unix:" addresses.
+func (svr *Server) SetUnixSocketOptions(opts UnixSocketOptions) {
+	svr.unixSocketOptions = opts
+}
+
+func (svr *Server) listen(addr string) (net.Listener, error) {
+	if ln, ok := inheritedListener(addr); ok {
+		return ln, nil
+	}
+	if strings.HasPrefix(addr, "unix:") {
+		return listenUnix(addr[5:], svr.unixSocketOptions)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// unixListener removes its socket file on Close, as long as the file is still
+// the one it created.
+type unixListener struct {
+	*net.UnixListener
+	path     string
+	info     os.FileInfo
+	keepFile bool
+}
+
+func (ln *unixListener) Close() error {
+	err := ln.UnixListener.Close()
+	if !ln.keepFile {
+		if info, statErr := os.Lstat(ln.path); statErr == nil && os.SameFile(info, ln.info) {
+			os.Remove(ln.path)
+		}
+	}
+	return err
+}
+
+func listenUnix(path string, opts UnixSocketOptions) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	uid, gid, err := lookupOwner(opts.User, opts.Group)
+	if err != nil {
+		return nil, err
+	}
+	// Bind to a temporary name and rename once permissions are set, so that
+	// no client can connect before the mode and owner are in place.
+	tmpPath := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.%d", filepath.Base(path), os.Getpid()))
+	os.Remove(tmpPath)
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	ln.SetUnlinkOnClose(false)
+	fail := func(err error) (net.Listener, error) {
+		ln.Close()
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	if opts.Mode != 0 {
+		if err := os.Chmod(tmpPath, opts.Mode); err != nil {
+			return fail(err)
+		}
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Lchown(tmpPath, uid, gid); err != nil {
+			return fail(err)
+		}
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fail(err)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fail(err)
+	}
+	return &unixListener{UnixListener: ln, path: path, info: info}, nil
+}
+
+// removeStaleSocket removes a socket file left by a previous process, after
+// making sure that nobody is listening on it anymore.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	if !isConnRefused(err) {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func isConnRefused(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		if sysErr, ok := opErr.Err.(*os.SyscallError); ok {
+			return sysErr.Err == syscall.ECONNREFUSED || sysErr.Err == syscall.ENOENT
+		}
+	}
+	return false
+}
+
+func lookupOwner(userName, groupName string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if userName != "" {
+		if uid, err = strconv.Atoi(userName); err != nil {
+			u, lookupErr := user.Lookup(userName)
+			if lookupErr != nil {
+				return -1, -1, lookupErr
+			}
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return -1, -1, err
+			}
+		}
+	}
+	if groupName != "" {
+		if gid, err = strconv.Atoi(groupName); err != nil {
+			g, lookupErr := user.LookupGroup(groupName)
+			if lookupErr != nil {
+				return -1, -1, lookupErr
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return -1, -1, err
+			}
+		}
+	}
+	return uid, gid, nil
+}
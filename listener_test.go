@@ -0,0 +1,87 @@
+//go:build !windows
+
+package niuhe
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestListenUnixStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "niuhe.sock")
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close() // leaves the socket file behind like a crashed process
+
+	ln, err := listenUnix(path, UnixSocketOptions{Mode: 0660})
+	if err != nil {
+		t.Fatalf("stale socket should be replaced: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTrue(t, info.Mode().Perm() == 0660, "socket mode should be 0660, got %v", info.Mode().Perm())
+
+	_, err = listenUnix(path, UnixSocketOptions{})
+	assertTrue(t, err != nil, "listening on a socket in use should fail")
+
+	ln.Close()
+	_, err = os.Stat(path)
+	assertTrue(t, os.IsNotExist(err), "socket file should be removed on close, got %v", err)
+}
+
+func TestListenerMatches(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+	assertTrue(t, listenerMatches(ln, net.JoinHostPort("127.0.0.1", strconv.Itoa(port))), "same address should match")
+	assertTrue(t, !listenerMatches(ln, net.JoinHostPort("", strconv.Itoa(port))), "loopback should not match unspecified host")
+	assertTrue(t, !listenerMatches(ln, "unix:/tmp/niuhe.sock"), "tcp listener should not match unix address")
+}
+
+func TestRestartWaitReady(t *testing.T) {
+	pipe := func() (*os.File, *os.File) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { r.Close(); w.Close() })
+		return r, w
+	}
+
+	r, w := pipe()
+	readyFile, readyOnce = w, sync.Once{}
+	notifyReady()
+	readyFile, readyOnce = nil, sync.Once{}
+	err := waitReady(r, nil, time.Second)
+	assertTrue(t, err == nil, "notifyReady should unblock the parent, got %v", err)
+
+	r, w = pipe()
+	w.Close()
+	err = waitReady(r, nil, time.Second)
+	assertTrue(t, err != nil, "closed pipe should fail")
+
+	r, _ = pipe()
+	exited := make(chan error, 1)
+	exited <- errors.New("exit status 1")
+	err = waitReady(r, exited, time.Second)
+	assertTrue(t, err != nil && strings.Contains(err.Error(), "exit status 1"), "exit should fail, got %v", err)
+
+	r, _ = pipe()
+	err = waitReady(r, nil, 10*time.Millisecond)
+	assertTrue(t, err != nil && strings.Contains(err.Error(), "not serving"), "timeout should fail, got %v", err)
+}
@@ -5,6 +5,8 @@ import (
 	"io"
 	"log"
 	"os"
+	"strings"
+	"sync/atomic"
 )
 
 const (
@@ -21,7 +23,7 @@ const (
 type LogPreHookType = func(level int, format string, args []interface{}) (newLevel int, newFormat string, newArgs []interface{})
 
 type LoggerT struct {
-	minLevel  int
+	minLevel  int32 // changed at runtime, e.g. by the admin module; use atomic
 	loggers   []*log.Logger
 	callbacks struct {
 		debug  []func(string)
@@ -33,35 +35,40 @@ type LoggerT struct {
 	}
 	preHook LogPreHookType
 	plugin  func(string) string // replacement plugin
+	parent  *LoggerT            // set for loggers derived by WithPrefix
+	prefix  string
 }
 
 var defaultLogger *LoggerT
 
-func MustParseLogLevelName(name string) int {
-	if name == "DEBUG" {
-		return LOG_DEBUG
-	}
-	if name == "INFO" {
-		return LOG_INFO
-	}
-	if name == "WARN" {
-		return LOG_WARN
-	}
-	if name == "ERROR" {
-		return LOG_ERROR
-	}
-	if name == "ASSERT" {
-		return LOG_ASSERT
+var logLevelNames = [end_log_level]string{"DEBUG", "INFO", "WARN", "ERROR", "ASSERT", "FATAL"}
+
+func ParseLogLevelName(name string) (int, bool) {
+	for level, levelName := range logLevelNames {
+		if name == levelName {
+			return level, true
+		}
 	}
-	if name == "FATAL" {
-		return LOG_FATAL
+	return 0, false
+}
+
+func MustParseLogLevelName(name string) int {
+	if level, ok := ParseLogLevelName(name); ok {
+		return level
 	}
 	panic("Unknown level name " + name)
 }
 
+func LogLevelName(level int) string {
+	if level < 0 || level >= end_log_level {
+		return "UNKNOWN"
+	}
+	return logLevelNames[level]
+}
+
 func NewLogger(out io.Writer, prefix string) *LoggerT {
 	return &LoggerT{
-		minLevel: LOG_INFO,
+		minLevel: int32(LOG_INFO),
 		loggers: []*log.Logger{
 			log.New(out, prefix+"[DBG]", DEFAULT_LOG_FLAGS),
 			log.New(out, prefix+"[INF]", DEFAULT_LOG_FLAGS),
@@ -74,15 +81,46 @@ func NewLogger(out io.Writer, prefix string) *LoggerT {
 	}
 }
 
+// root is the logger owning the outputs, level, hooks and callbacks used by l.
+func (l *LoggerT) root() *LoggerT {
+	for l.parent != nil {
+		l = l.parent
+	}
+	return l
+}
+
+func (l *LoggerT) SetOutput(out io.Writer) {
+	l = l.root()
+	for _, logger := range l.loggers {
+		logger.SetOutput(out)
+	}
+}
+
 func (l *LoggerT) SetLogLevel(logLevel int) {
-	l.minLevel = logLevel
+	l = l.root()
+	atomic.StoreInt32(&l.minLevel, int32(logLevel))
+}
+
+// WithPrefix returns a logger writing through l with prefix in front of every
+// message. It follows the level, hooks and callbacks of l; setting them on
+// the returned logger changes l.
+func (l *LoggerT) WithPrefix(prefix string) *LoggerT {
+	return &LoggerT{parent: l, prefix: prefix}
 }
 
 func (l *LoggerT) log(level int, calldepth int, format string, args ...interface{}) bool {
+	if l.parent != nil {
+		if len(args) > 0 {
+			format = strings.ReplaceAll(l.prefix, "%", "%%") + format
+		} else {
+			format = l.prefix + format
+		}
+		return l.parent.log(level, calldepth+1, format, args...)
+	}
 	if l.preHook != nil {
 		level, format, args = l.preHook(level, format, args)
 	}
-	if level < l.minLevel || level >= end_log_level {
+	if level < l.logLevel() || level >= end_log_level {
 		return false
 	}
 	logger := l.loggers[level]
@@ -175,7 +213,8 @@ func (l *LoggerT) FatalUp(up int, format string, args ...interface{}) bool {
 }
 
 func (l *LoggerT) logLevel() int {
-	return l.minLevel
+	l = l.root()
+	return int(atomic.LoadInt32(&l.minLevel))
 }
 
 // Logger export default looger
@@ -184,6 +223,7 @@ func Logger() *LoggerT {
 }
 
 func (l *LoggerT) AddCallback(level int, callbackFunc func(string)) {
+	l = l.root()
 	switch level {
 	case LOG_DEBUG:
 		l.callbacks.debug = append(l.callbacks.debug, callbackFunc)
@@ -201,21 +241,25 @@ func (l *LoggerT) AddCallback(level int, callbackFunc func(string)) {
 }
 
 func (l *LoggerT) SetPlugin(plugin func(string) string) {
-	l.plugin = plugin
+	l.root().plugin = plugin
 }
 
 func (l *LoggerT) SetPreHook(f LogPreHookType) {
-	l.preHook = f
+	l.root().preHook = f
 }
 
 func LogLevel() int {
-	return defaultLogger.minLevel
+	return defaultLogger.logLevel()
 }
 
 func SetLogLevel(logLevel int) {
 	defaultLogger.SetLogLevel(logLevel)
 }
 
+func SetLogOutput(out io.Writer) {
+	defaultLogger.SetOutput(out)
+}
+
 func LogDebug(format string, args ...interface{}) bool {
 	return defaultLogger.log(LOG_DEBUG, 3, format, args...)
 }
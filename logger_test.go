@@ -2,7 +2,9 @@ package niuhe
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"sync"
 	"testing"
 )
 
@@ -48,3 +50,24 @@ func TestNewLogger(t *testing.T) {
 	logger.Error("x 4")
 	logger.Fatal("x 5")
 }
+
+func TestSetLogLevelConcurrently(t *testing.T) {
+	logger := NewLogger(io.Discard, "")
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			logger.SetLogLevel(i % end_log_level)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			logger.Debug("x %d", i)
+		}
+	}()
+	wg.Wait()
+	logger.SetLogLevel(LOG_ERROR)
+	assertTrue(t, logger.logLevel() == LOG_ERROR, "level %d", logger.logLevel())
+}
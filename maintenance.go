@@ -0,0 +1,193 @@
+package niuhe
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type MaintenanceOptions struct {
+	// Error is returned by every route while in maintenance, defaults to
+	// CommError(-1, "service under maintenance").
+	Error error
+	// AllowRoutes lists routes still served, as returned by
+	// Context.RoutePath, e.g. "/api/user/login/", or "/svc/api/user/login/"
+	// after SetPathPrefix("/svc"); a trailing "*" matches a prefix.
+	AllowRoutes []string
+	// AllowIPs lists client IPs or CIDRs still served, e.g. the office network.
+	AllowIPs []string
+	// FlagFile turns maintenance on while the file exists. It is checked
+	// every FlagInterval, one second by default.
+	FlagFile     string
+	FlagInterval time.Duration
+	// Signal toggles maintenance when received, e.g. syscall.SIGUSR1.
+	Signal os.Signal
+}
+
+type maintenanceState struct {
+	opts     MaintenanceOptions
+	nets     []*net.IPNet
+	manual   int32 // set by SetMaintenance or Signal
+	flagFile int32 // set while FlagFile exists
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+}
+
+// EnableMaintenance installs the maintenance switch. It starts off unless
+// FlagFile exists; use SetMaintenance, the flag file or the signal to turn it on.
+// The flag file and the signal are watched from the time the engine is built
+// until Shutdown. Modules marked with SkipMaintenance, like the admin module,
+// are served anyway.
+func (svr *Server) EnableMaintenance(opts MaintenanceOptions) {
+	if opts.Error == nil {
+		opts.Error = NewCommError(-1, "service under maintenance")
+	}
+	if opts.FlagInterval <= 0 {
+		opts.FlagInterval = time.Second
+	}
+	state := &maintenanceState{opts: opts, stop: make(chan struct{})}
+	for _, ip := range opts.AllowIPs {
+		if !strings.Contains(ip, "/") {
+			if strings.Contains(ip, ":") {
+				ip += "/128"
+			} else {
+				ip += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(ip)
+		if err != nil {
+			panic("EnableMaintenance: invalid AllowIPs entry " + ip)
+		}
+		state.nets = append(state.nets, ipNet)
+	}
+	state.checkFlagFile()
+	svr.maintenance = state
+	svr.OnShutdown(func(ctx context.Context) error {
+		state.close()
+		return nil
+	})
+}
+
+// SkipMaintenance keeps the routes of the module served in maintenance, e.g.
+// so that maintenance can be turned off through them.
+func (mod *Module) SkipMaintenance() *Module {
+	mod.skipMaintenance = true
+	return mod
+}
+
+// SetMaintenance turns maintenance mode on or off. EnableMaintenance must
+// have been called before the engine was built. A present flag file keeps
+// maintenance on.
+func (svr *Server) SetMaintenance(on bool) {
+	if svr.maintenance == nil {
+		panic("SetMaintenance: call EnableMaintenance before serving")
+	}
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&svr.maintenance.manual, v)
+}
+
+// InMaintenance reports whether requests are being rejected.
+func (svr *Server) InMaintenance() bool {
+	return svr.maintenance != nil && svr.maintenance.on()
+}
+
+func (m *maintenanceState) on() bool {
+	return atomic.LoadInt32(&m.manual) != 0 || atomic.LoadInt32(&m.flagFile) != 0
+}
+
+func (m *maintenanceState) checkFlagFile() {
+	if m.opts.FlagFile == "" {
+		return
+	}
+	var v int32
+	if _, err := os.Stat(m.opts.FlagFile); err == nil {
+		v = 1
+	}
+	if atomic.SwapInt32(&m.flagFile, v) != v {
+		LogWarn("maintenance flag file %s: maintenance %v", m.opts.FlagFile, v != 0)
+	}
+}
+
+func (m *maintenanceState) allowed(c *Context) bool {
+	routePath := c.RoutePath()
+	for _, route := range m.opts.AllowRoutes {
+		if route == routePath || (strings.HasSuffix(route, "*") && strings.HasPrefix(routePath, route[:len(route)-1])) {
+			return true
+		}
+	}
+	if len(m.nets) > 0 {
+		if ip := net.ParseIP(c.ClientIP()); ip != nil {
+			for _, ipNet := range m.nets {
+				if ipNet.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// middleware runs first on every route, so the error is written by the
+// protocol of the route.
+func (m *maintenanceState) middleware(c *Context) {
+	if m.on() && !m.allowed(c) {
+		c.AbortWithApiError(m.opts.Error)
+		return
+	}
+	c.Next()
+}
+
+// start runs watch once, whichever engine is built first.
+func (m *maintenanceState) start() {
+	m.startOnce.Do(func() {
+		go m.watch(m.stop)
+	})
+}
+
+func (m *maintenanceState) close() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+// watch polls the flag file and listens for the toggle signal until stop is closed.
+func (m *maintenanceState) watch(stop <-chan struct{}) {
+	var ticks <-chan time.Time
+	if m.opts.FlagFile != "" {
+		ticker := time.NewTicker(m.opts.FlagInterval)
+		ticks = ticker.C
+		defer ticker.Stop()
+	}
+	var signals chan os.Signal
+	if m.opts.Signal != nil {
+		signals = make(chan os.Signal, 1)
+		signal.Notify(signals, m.opts.Signal)
+		defer signal.Stop(signals)
+	}
+	for {
+		select {
+		case <-ticks:
+			m.checkFlagFile()
+		case <-signals:
+			on := atomic.LoadInt32(&m.manual) == 0
+			if on {
+				atomic.StoreInt32(&m.manual, 1)
+			} else {
+				atomic.StoreInt32(&m.manual, 0)
+			}
+			LogWarn("maintenance toggled by signal: %v", on)
+		case <-stop:
+			return
+		}
+	}
+}
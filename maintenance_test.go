@@ -0,0 +1,89 @@
+package niuhe
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	flagFile := filepath.Join(t.TempDir(), "maintenance")
+	svr := NewServer()
+	svr.EnableMaintenance(MaintenanceOptions{
+		Error:       NewCommError(503, "down for migration"),
+		AllowRoutes: []string{"/api/vhost_test/*"},
+		FlagFile:    flagFile,
+	})
+	svr.RegisterModule(NewModule("/api").Register(&IdemTest{}).Register(&VhostTest{name: "up"}))
+	engine := svr.GetGinEngine()
+
+	w := doRequest(engine, http.MethodPost, "/api/idem_test/create/", nil)
+	assertTrue(t, strings.Contains(w.Body.String(), `"result":0`), "maintenance should start off, got %s", w.Body)
+
+	svr.SetMaintenance(true)
+	w = doRequest(engine, http.MethodPost, "/api/idem_test/create/", nil)
+	assertTrue(t, strings.Contains(w.Body.String(), `"result":503`), "route should be rejected in maintenance, got %s", w.Body)
+	w = doRequest(engine, http.MethodGet, "/api/vhost_test/name/", nil)
+	assertTrue(t, strings.Contains(w.Body.String(), `"name":"up"`), "allowlisted route should be served, got %s", w.Body)
+	svr.SetMaintenance(false)
+	assertTrue(t, !svr.InMaintenance(), "maintenance should be off")
+
+	if err := os.WriteFile(flagFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	svr.maintenance.checkFlagFile()
+	assertTrue(t, svr.InMaintenance(), "flag file should turn maintenance on")
+	os.Remove(flagFile)
+	svr.maintenance.checkFlagFile()
+	assertTrue(t, !svr.InMaintenance(), "removing the flag file should turn maintenance off")
+}
+
+func TestMaintenanceAllowIPs(t *testing.T) {
+	svr := NewServer()
+	svr.EnableMaintenance(MaintenanceOptions{AllowIPs: []string{"192.0.2.0/24"}})
+	svr.RegisterModule(NewModule("/api").Register(&IdemTest{}))
+	engine := svr.GetGinEngine()
+	svr.SetMaintenance(true)
+
+	w := doRequest(engine, http.MethodPost, "/api/idem_test/create/", nil) // httptest uses 192.0.2.1
+	assertTrue(t, strings.Contains(w.Body.String(), `"result":0`), "allowlisted IP should be served, got %s", w.Body)
+	svr.maintenance.nets = nil
+	w = doRequest(engine, http.MethodPost, "/api/idem_test/create/", nil)
+	assertTrue(t, strings.Contains(w.Body.String(), "service under maintenance"), "other IPs should be rejected, got %s", w.Body)
+}
+
+func TestMaintenanceWatchStartsWithEngine(t *testing.T) {
+	flagFile := filepath.Join(t.TempDir(), "maintenance")
+	svr := NewServer()
+	svr.EnableMaintenance(MaintenanceOptions{FlagFile: flagFile, FlagInterval: 10 * time.Millisecond})
+	svr.RegisterModule(NewModule("/api").Register(&IdemTest{}))
+	svr.GetGinEngine()
+	defer svr.Shutdown(context.Background())
+
+	if err := os.WriteFile(flagFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !svr.InMaintenance() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertTrue(t, svr.InMaintenance(), "flag file should be watched without ServeContext")
+}
+
+func TestMaintenanceAllowRoutesWithPathPrefix(t *testing.T) {
+	svr := NewServer()
+	svr.SetPathPrefix("/svc")
+	svr.EnableMaintenance(MaintenanceOptions{AllowRoutes: []string{"/svc/api/vhost_test/*"}})
+	svr.RegisterModule(NewModule("/api").Register(&IdemTest{}).Register(&VhostTest{name: "up"}))
+	engine := svr.GetGinEngine()
+	svr.SetMaintenance(true)
+
+	w := doRequest(engine, http.MethodGet, "/svc/api/vhost_test/name/", nil)
+	assertTrue(t, strings.Contains(w.Body.String(), `"name":"up"`), "allowlisted route should be served, got %s", w.Body)
+	w = doRequest(engine, http.MethodPost, "/svc/api/idem_test/create/", nil)
+	assertTrue(t, strings.Contains(w.Body.String(), "service under maintenance"), "other routes should be rejected, got %s", w.Body)
+}
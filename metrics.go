@@ -0,0 +1,222 @@
+package niuhe
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(buckets))
+	}
+	for i, le := range buckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+type requestKey struct {
+	route, method, status, result string
+}
+
+// Metrics collects per-route request metrics and renders them in the
+// Prometheus text exposition format.
+type Metrics struct {
+	lock      sync.Mutex
+	buckets   []float64
+	requests  map[requestKey]uint64
+	latencies map[string]*histogram // by route
+	injectors map[string]*histogram // by injected type
+	queries   map[string]*histogram // by SQL statement kind
+	inFlight  int64
+}
+
+func NewMetrics(buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &Metrics{
+		buckets:   buckets,
+		requests:  make(map[requestKey]uint64),
+		latencies: make(map[string]*histogram),
+		injectors: make(map[string]*histogram),
+		queries:   make(map[string]*histogram),
+	}
+}
+
+// EnableMetrics collects request metrics for every route and exposes them on path.
+func (svr *Server) EnableMetrics(path string) *Metrics {
+	svr.metrics = NewMetrics()
+	svr.metricsPath = path
+	return svr.metrics
+}
+
+func observeIn(m map[string]*histogram, buckets []float64, key string, v float64) {
+	h, exists := m[key]
+	if !exists {
+		h = &histogram{}
+		m[key] = h
+	}
+	h.observe(buckets, v)
+}
+
+// Middleware is a gin middleware recording in-flight requests, request counts
+// by route, status and envelope result code, latencies and injector timings.
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		atomic.AddInt64(&m.inFlight, 1)
+		start := time.Now()
+		defer atomic.AddInt64(&m.inFlight, -1)
+		c.Next()
+		latency := time.Since(start).Seconds()
+
+		key := requestKey{method: c.Request.Method, status: strconv.Itoa(c.Writer.Status())}
+		var injected []injectTiming
+		if context := GetContext(c); context != nil {
+			key.route = context.RoutePath()
+			if code, _, ok := context.ApiResult(); ok {
+				key.result = strconv.Itoa(code)
+			}
+			injected = context.injected
+		} else if key.route = c.FullPath(); key.route == "" {
+			key.route = "unmatched"
+		}
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		m.requests[key]++
+		observeIn(m.latencies, m.buckets, key.route, latency)
+		for _, it := range injected {
+			observeIn(m.injectors, m.buckets, it.t.String(), it.duration.Seconds())
+		}
+	}
+}
+
+// ObserveDB records the duration of a database statement. kind is usually the
+// SQL verb, see db.NewTimingHook.
+func (m *Metrics) ObserveDB(kind string, duration time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	observeIn(m.queries, m.buckets, kind, duration.Seconds())
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, values ...string) string {
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return strings.Join(parts, ",")
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (m *Metrics) writeHistograms(w *bufio.Writer, name, help, label string, hs map[string]*histogram) {
+	if len(hs) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	keys := make([]string, 0, len(hs))
+	for k := range hs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h := hs[k]
+		labels := formatLabels([]string{label}, k)
+		var cumulative uint64
+		for i, le := range m.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+	}
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(out io.Writer) (int64, error) {
+	cw := &countingWriter{w: out}
+	w := bufio.NewWriter(cw)
+	m.lock.Lock()
+	fmt.Fprintf(w, "# HELP niuhe_http_requests_in_flight Requests currently being served.\n")
+	fmt.Fprintf(w, "# TYPE niuhe_http_requests_in_flight gauge\n")
+	fmt.Fprintf(w, "niuhe_http_requests_in_flight %d\n", atomic.LoadInt64(&m.inFlight))
+	if len(m.requests) > 0 {
+		fmt.Fprintf(w, "# HELP niuhe_http_requests_total Requests by route, HTTP status and envelope result code.\n")
+		fmt.Fprintf(w, "# TYPE niuhe_http_requests_total counter\n")
+		keys := make([]requestKey, 0, len(m.requests))
+		for k := range m.requests {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, b := keys[i], keys[j]
+			if a.route != b.route {
+				return a.route < b.route
+			} else if a.method != b.method {
+				return a.method < b.method
+			} else if a.status != b.status {
+				return a.status < b.status
+			}
+			return a.result < b.result
+		})
+		for _, k := range keys {
+			fmt.Fprintf(w, "niuhe_http_requests_total{%s} %d\n",
+				formatLabels([]string{"route", "method", "status", "result"}, k.route, k.method, k.status, k.result),
+				m.requests[k])
+		}
+	}
+	m.writeHistograms(w, "niuhe_http_request_duration_seconds", "Request latency by route.", "route", m.latencies)
+	m.writeHistograms(w, "niuhe_injector_duration_seconds", "Time spent in dependency injectors by type.", "type", m.injectors)
+	m.writeHistograms(w, "niuhe_db_duration_seconds", "Database statement latency by kind.", "kind", m.queries)
+	m.lock.Unlock()
+	err := w.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
@@ -0,0 +1,41 @@
+package niuhe
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	mod := NewModule("/api")
+	mod.Register(&IdemTest{})
+	svr := NewServer()
+	svr.RegisterModule(mod)
+	svr.EnableMetrics("/metrics")
+	engine := svr.GetGinEngine()
+
+	doRequest(engine, http.MethodPost, "/api/idem_test/create/", nil)
+	doRequest(engine, http.MethodGet, "/missing", nil)
+	body := doRequest(engine, http.MethodGet, "/metrics", nil).Body.String()
+	for _, want := range []string{
+		`niuhe_http_requests_total{route="/api/idem_test/create/",method="POST",status="200",result="0"} 1`,
+		`niuhe_http_requests_total{route="unmatched",method="GET",status="404",result=""} 1`,
+		`niuhe_http_request_duration_seconds_count{route="/api/idem_test/create/"} 1`,
+		`niuhe_http_requests_in_flight 0`,
+	} {
+		assertTrue(t, strings.Contains(body, want), "metrics should contain %s, got:\n%s", want, body)
+	}
+}
+
+func TestMetricsWithPathPrefix(t *testing.T) {
+	svr := NewServer()
+	svr.SetPathPrefix("/svc")
+	svr.RegisterModule(NewModule("/api").Register(&IdemTest{}))
+	svr.EnableMetrics("/metrics")
+	engine := svr.GetGinEngine()
+
+	doRequest(engine, http.MethodPost, "/svc/api/idem_test/create/", nil)
+	body := doRequest(engine, http.MethodGet, "/metrics", nil).Body.String()
+	want := `niuhe_http_requests_total{route="/svc/api/idem_test/create/",method="POST",status="200",result="0"} 1`
+	assertTrue(t, strings.Contains(body, want), "route label should include the server prefix, got:\n%s", body)
+}
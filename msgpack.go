@@ -0,0 +1,71 @@
+package niuhe
+
+import (
+	"net/http"
+	"reflect"
+
+	"github.com/ugorji/go/codec"
+)
+
+const MsgpackContentType = "application/x-msgpack"
+
+// msgpackHandle honors json tags, so request and response types need no
+// extra tags; map keys are sorted for stable output.
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true
+	h.RawToString = true
+	h.Canonical = true
+	return h
+}()
+
+// DecodeMsgpackRequest reads a MessagePack request body into reqValue.
+func DecodeMsgpackRequest(c *Context, reqValue reflect.Value) error {
+	if err := codec.NewDecoder(c.Request.Body, msgpackHandle).Decode(reqValue.Interface()); err != nil {
+		return NewCommError(-1, err.Error())
+	}
+	return nil
+}
+
+// EncodeMsgpackResponse serializes the envelope built by the API protocols.
+func EncodeMsgpackResponse(c *Context, body interface{}) ([]byte, error) {
+	var data []byte
+	if err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(body); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+type msgpackApiProtocol struct{}
+
+func (self msgpackApiProtocol) Read(c *Context, reqValue reflect.Value) error {
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return decodeForm(c, reqValue)
+	}
+	return DecodeMsgpackRequest(c, reqValue)
+}
+
+func (self msgpackApiProtocol) Write(c *Context, rsp reflect.Value, err error) error {
+	data, encodeErr := EncodeMsgpackResponse(c, buildEnvelope(c, rsp, err))
+	if encodeErr != nil {
+		return encodeErr
+	}
+	c.Data(200, MsgpackContentType, data)
+	return nil
+}
+
+var msgpackApiProtocolInstance msgpackApiProtocol
+
+// MsgpackApiProtocolFactory reads and writes MessagePack bodies with the
+// envelope of DefaultApiProtocol. Requests without a body are read from
+// the query string. NegotiatingApiProtocolFactory also speaks MessagePack
+// to clients asking for it.
+var MsgpackApiProtocolFactory = ApiProtocolFactoryFunc(func() IApiProtocol {
+	return &msgpackApiProtocolInstance
+})
+
+func init() {
+	RegisterRequestDecoder(MsgpackContentType, DecodeMsgpackRequest)
+	RegisterRequestDecoder("application/msgpack", DecodeMsgpackRequest)
+	RegisterResponseEncoder(MsgpackContentType, EncodeMsgpackResponse)
+}
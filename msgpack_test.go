@@ -0,0 +1,69 @@
+package niuhe
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+)
+
+type msgpackTestReq struct {
+	Name  string `json:"name"`
+	Level OpInt  `json:"level"`
+}
+
+type msgpackTestRsp struct {
+	Greeting string `json:"greeting"`
+	Level    OpInt  `json:"level"`
+	Title    OpStr  `json:"title"`
+}
+
+type MsgpackTest struct{}
+
+func (api *MsgpackTest) Hello_POST(c *Context, req *msgpackTestReq, rsp *msgpackTestRsp) error {
+	rsp.Greeting = "hello " + req.Name
+	if level, exists := req.Level.Value(); exists {
+		rsp.Level.Set(level + 1)
+	}
+	return nil
+}
+
+func TestMsgpackProtocol(t *testing.T) {
+	svr := NewServer()
+	svr.RegisterModule(NewModuleWithProtocolFactory("/api", MsgpackApiProtocolFactory).Register(&MsgpackTest{}))
+	engine := svr.GetGinEngine()
+
+	var body []byte
+	codec.NewEncoderBytes(&body, msgpackHandle).MustEncode(map[string]interface{}{"name": "player", "level": 9})
+	req := httptest.NewRequest(http.MethodPost, "/api/msgpack_test/hello/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", MsgpackContentType)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	assertTrue(t, w.Header().Get("Content-Type") == MsgpackContentType, "unexpected content type %s", w.Header().Get("Content-Type"))
+
+	var rsp struct {
+		Result int                    `json:"result"`
+		Data   map[string]interface{} `json:"data"`
+	}
+	if err := codec.NewDecoderBytes(w.Body.Bytes(), msgpackHandle).Decode(&rsp); err != nil {
+		t.Fatal(err)
+	}
+	assertTrue(t, rsp.Result == 0 && rsp.Data["greeting"] == "hello player", "unexpected response %+v", rsp)
+	assertTrue(t, rsp.Data["level"] == int64(10), "level should be 10, got %#v", rsp.Data["level"])
+	title, exists := rsp.Data["title"]
+	assertTrue(t, exists && title == nil, "unset title should be nil, got %#v", title)
+}
+
+func TestOpValueMsgpack(t *testing.T) {
+	var data struct {
+		Number OpInt `json:"n"`
+		Text   OpStr `json:"s"`
+	}
+	var buf []byte
+	codec.NewEncoderBytes(&buf, msgpackHandle).MustEncode(map[string]interface{}{"n": 3, "s": nil})
+	codec.NewDecoderBytes(buf, msgpackHandle).MustDecode(&data)
+	assertTrue(t, data.Number.MustValue() == 3, "data.Number should be 3")
+	assertTrue(t, !data.Text.Exists(), "data.Text should be not exists")
+}
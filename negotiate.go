@@ -0,0 +1,352 @@
+package niuhe
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/ziipin-server/zpform"
+)
+
+// RequestDecoder reads the request into reqValue, a pointer to the request struct.
+type RequestDecoder func(c *Context, reqValue reflect.Value) error
+
+// ResponseEncoder serializes body, the response envelope or a custom root.
+type ResponseEncoder func(c *Context, body interface{}) ([]byte, error)
+
+var requestDecoders = map[string]RequestDecoder{}
+
+type responseEncoderEntry struct {
+	contentType string
+	mediaType   string
+	encode      ResponseEncoder
+	supports    func(rspType reflect.Type) bool
+}
+
+// supportsRoute tells whether the encoder can write the responses of the
+// route of c.
+func (entry *responseEncoderEntry) supportsRoute(c *Context) bool {
+	if entry.supports == nil {
+		return true
+	}
+	return c.rspType != nil && entry.supports(c.rspType)
+}
+
+var responseEncoders []responseEncoderEntry
+
+// RegisterRequestDecoder makes NegotiatingApiProtocolFactory read requests of
+// mediaType, e.g. "application/json", with dec. It replaces the decoder
+// already registered for mediaType and must be called during initialization.
+func RegisterRequestDecoder(mediaType string, dec RequestDecoder) {
+	requestDecoders[strings.ToLower(mediaType)] = dec
+}
+
+// RegisterResponseEncoder makes NegotiatingApiProtocolFactory write responses
+// with enc to clients accepting contentType, e.g. "application/xml; charset=utf-8".
+// JSON is written to clients accepting anything. It must be called during
+// initialization.
+func RegisterResponseEncoder(contentType string, enc ResponseEncoder) {
+	RegisterResponseEncoderFor(contentType, enc, nil)
+}
+
+// RegisterResponseEncoderFor registers an encoder writing only some response
+// types, e.g. proto-generated messages: clients asking for contentType get
+// another format on routes whose response type, a struct, is not supported.
+func RegisterResponseEncoderFor(contentType string, enc ResponseEncoder, supports func(rspType reflect.Type) bool) {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for i, entry := range responseEncoders {
+		if entry.mediaType == mediaType {
+			responseEncoders[i] = responseEncoderEntry{contentType, mediaType, enc, supports}
+			return
+		}
+	}
+	responseEncoders = append(responseEncoders, responseEncoderEntry{contentType, mediaType, enc, supports})
+}
+
+func decodeForm(c *Context, reqValue reflect.Value) error {
+	if err := zpform.ReadReflectedStructForm(c.Request, reqValue); err != nil {
+		return NewCommError(-1, err.Error())
+	}
+	return nil
+}
+
+func decodeMultipartForm(c *Context, reqValue reflect.Value) error {
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+		return NewCommError(-1, err.Error())
+	}
+	return decodeForm(c, reqValue)
+}
+
+func decodeJSON(c *Context, reqValue reflect.Value) error {
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return NewCommError(-1, err.Error())
+	}
+	return decodeJSONRequest(data, reqValue)
+}
+
+// decodeJSONRequest decodes data like binding.JSON and then runs the zpform
+// validators of the request struct, which forms are checked with.
+func decodeJSONRequest(data []byte, reqValue reflect.Value) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if binding.EnableDecoderUseNumber {
+		decoder.UseNumber()
+	}
+	if binding.EnableDecoderDisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(reqValue.Interface()); err != nil {
+		return NewCommError(-1, err.Error())
+	}
+	if err := binding.Validator.ValidateStruct(reqValue.Interface()); err != nil {
+		return NewCommError(-1, err.Error())
+	}
+	return validateJSONFields(reqValue, data)
+}
+
+// validateJSONFields runs the zpform validators (zpf_reqd, zpf_len, ...) on
+// the JSON values of the fields of the request, as zpform does on form
+// values: missing fields are validated as empty strings and the elements of
+// lists one by one.
+func validateJSONFields(reqValue reflect.Value, data []byte) error {
+	var values map[string]json.RawMessage
+	json.Unmarshal(data, &values)
+	bindings := zpform.GetReflectedBindings(reqValue)
+	structValue := reqValue.Elem()
+	for i := 0; i < structValue.NumField(); i++ {
+		field := structValue.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		addr := structValue.Field(i).Addr().Pointer()
+		for _, b := range bindings {
+			if len(b.ValidateFunc) == 0 || b.FieldVar.Pointer() != addr || b.FieldVar.Type().Elem() != field.Type {
+				continue
+			}
+			raw, exists := jsonFieldValue(values, field)
+			var formValues []string
+			if field.Type.Kind() == reflect.Slice {
+				if !exists {
+					continue
+				}
+				formValues = jsonFormValues(raw)
+			} else {
+				formValues = []string{jsonFormValue(raw)}
+			}
+			for _, value := range formValues {
+				for _, validate := range b.ValidateFunc {
+					if ok, msg := validate(value); !ok {
+						if msg == "" {
+							msg = "not valid"
+						}
+						return NewCommError(-1, fmt.Sprintf("%v（%v）", b.FieldLabel, msg))
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// jsonFieldValue looks the field up like encoding/json does.
+func jsonFieldValue(values map[string]json.RawMessage, field reflect.StructField) (json.RawMessage, bool) {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return nil, false
+	} else if name == "" {
+		name = field.Name
+	}
+	if raw, exists := values[name]; exists {
+		return raw, string(raw) != "null"
+	}
+	for key, raw := range values {
+		if strings.EqualFold(key, name) {
+			return raw, string(raw) != "null"
+		}
+	}
+	return nil, false
+}
+
+// jsonFormValue returns the form value standing for raw: strings unquoted,
+// other values as written and null as an empty value.
+func jsonFormValue(raw json.RawMessage) string {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	var s string
+	if raw[0] == '"' && json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return string(raw)
+}
+
+func jsonFormValues(raw json.RawMessage) []string {
+	var items []json.RawMessage
+	if json.Unmarshal(raw, &items) != nil {
+		return []string{jsonFormValue(raw)}
+	}
+	values := make([]string, len(items))
+	for i, item := range items {
+		values[i] = jsonFormValue(item)
+	}
+	return values
+}
+
+func bindingDecoder(b binding.Binding) RequestDecoder {
+	return func(c *Context, reqValue reflect.Value) error {
+		if err := b.Bind(c.Request, reqValue.Interface()); err != nil {
+			return NewCommError(-1, err.Error())
+		}
+		return nil
+	}
+}
+
+// xmlEnvelope writes the envelope map as <response><result>0</result>...</response>.
+type xmlEnvelope map[string]interface{}
+
+func (env xmlEnvelope) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start = xml.StartElement{Name: xml.Name{Local: "response"}}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if env[key] == nil {
+			continue
+		}
+		if err := e.EncodeElement(env[key], xml.StartElement{Name: xml.Name{Local: key}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+func encodeJSON(c *Context, body interface{}) ([]byte, error) {
+	return json.Marshal(body)
+}
+
+func encodeXML(c *Context, body interface{}) ([]byte, error) {
+	if env, ok := body.(map[string]interface{}); ok {
+		body = xmlEnvelope(env)
+	}
+	return xml.Marshal(body)
+}
+
+type negotiatingApiProtocol struct{}
+
+func (self negotiatingApiProtocol) Read(c *Context, reqValue reflect.Value) error {
+	mediaType := strings.ToLower(c.ContentType())
+	if mediaType == "" || c.Request.Body == nil || c.Request.Body == http.NoBody {
+		// requests without a body carry their parameters in the query string
+		return decodeForm(c, reqValue)
+	}
+	dec, exists := requestDecoders[mediaType]
+	if !exists {
+		return NewCommError(-1, "unsupported content type "+mediaType)
+	}
+	return dec(c, reqValue)
+}
+
+func defaultEncoder() responseEncoderEntry {
+	for _, entry := range responseEncoders {
+		if entry.mediaType == "application/json" {
+			return entry
+		}
+	}
+	return responseEncoders[0]
+}
+
+// acceptedEncoder picks the registered encoder preferred by the Accept
+// header among those supporting the route of c, defaulting to JSON.
+// Wildcards like application/* pick JSON too when it matches, whatever the
+// order encoders were registered in.
+func acceptedEncoder(c *Context, accept string) responseEncoderEntry {
+	type acceptItem struct {
+		mediaType string
+		q         float64
+	}
+	var items []acceptItem
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		item := acceptItem{mediaType: strings.ToLower(strings.TrimSpace(fields[0])), q: 1}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					item.q = q
+				}
+			}
+		}
+		if item.mediaType != "" && item.q > 0 {
+			items = append(items, item)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].q > items[j].q })
+	for _, item := range items {
+		if item.mediaType == "*/*" {
+			return defaultEncoder()
+		}
+		if strings.HasSuffix(item.mediaType, "/*") {
+			prefix := item.mediaType[:len(item.mediaType)-1]
+			if entry := defaultEncoder(); strings.HasPrefix(entry.mediaType, prefix) {
+				return entry
+			}
+			for _, entry := range responseEncoders {
+				if strings.HasPrefix(entry.mediaType, prefix) && entry.supportsRoute(c) {
+					return entry
+				}
+			}
+			continue
+		}
+		for _, entry := range responseEncoders {
+			if item.mediaType == entry.mediaType && entry.supportsRoute(c) {
+				return entry
+			}
+		}
+	}
+	return defaultEncoder()
+}
+
+func (self negotiatingApiProtocol) Write(c *Context, rsp reflect.Value, err error) error {
+	entry := acceptedEncoder(c, c.GetHeader("Accept"))
+	data, encodeErr := entry.encode(c, buildEnvelope(c, rsp, err))
+	if encodeErr != nil {
+		return encodeErr
+	}
+	c.Header("Vary", "Accept")
+	c.Data(200, entry.contentType, data)
+	return nil
+}
+
+var negotiatingApiProtocolInstance negotiatingApiProtocol
+
+// NegotiatingApiProtocolFactory reads requests according to their
+// Content-Type (form, multipart, JSON, XML, protobuf and MessagePack by
+// default, see RegisterRequestDecoder) and writes responses in the format
+// preferred by the Accept header (see RegisterResponseEncoder).
+var NegotiatingApiProtocolFactory = ApiProtocolFactoryFunc(func() IApiProtocol {
+	return &negotiatingApiProtocolInstance
+})
+
+func init() {
+	RegisterRequestDecoder("application/x-www-form-urlencoded", decodeForm)
+	RegisterRequestDecoder("multipart/form-data", decodeMultipartForm)
+	RegisterRequestDecoder("application/json", decodeJSON)
+	RegisterRequestDecoder("application/xml", bindingDecoder(binding.XML))
+	RegisterRequestDecoder("text/xml", bindingDecoder(binding.XML))
+	RegisterResponseEncoder("application/json; charset=utf-8", encodeJSON)
+	RegisterResponseEncoder("application/xml; charset=utf-8", encodeXML)
+}
@@ -0,0 +1,92 @@
+package niuhe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type negotiateTestReq struct {
+	Name string `json:"name" xml:"name"`
+}
+
+type negotiateTestRsp struct {
+	Greeting string `json:"greeting" xml:"greeting"`
+}
+
+type NegotiateTest struct{}
+
+func (api *NegotiateTest) Hello(c *Context, req *negotiateTestReq, rsp *negotiateTestRsp) error {
+	rsp.Greeting = "hello " + req.Name
+	return nil
+}
+
+type negotiateValidateReq struct {
+	Name string   `json:"name" zpf_name:"name" zpf_reqd:"true"`
+	Age  int      `json:"age" zpf_name:"age" zpf_maxnum:"150"`
+	Tags []string `json:"tags" zpf_name:"tags" zpf_maxlen:"3"`
+}
+
+func (api *NegotiateTest) Validate(c *Context, req *negotiateValidateReq, rsp *negotiateTestRsp) error {
+	rsp.Greeting = "hello " + req.Name
+	return nil
+}
+
+func TestNegotiatingProtocol(t *testing.T) {
+	mod := NewModuleWithProtocolFactory("/api", NegotiatingApiProtocolFactory)
+	svr := NewServer()
+	svr.RegisterModule(mod.Register(&NegotiateTest{}))
+	engine := svr.GetGinEngine()
+
+	send := func(method, path, contentType, body, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body == "" {
+			req.Body = http.NoBody
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(http.MethodPost, "/api/negotiate_test/hello/", "application/x-www-form-urlencoded", "name=form", "")
+	assertTrue(t, strings.Contains(w.Body.String(), `"greeting":"hello form"`), "form request should be read, got %s", w.Body)
+	w = send(http.MethodPost, "/api/negotiate_test/hello/", "application/json", `{"name":"json"}`, "application/json")
+	assertTrue(t, strings.Contains(w.Body.String(), `"greeting":"hello json"`), "json request should be read, got %s", w.Body)
+	w = send(http.MethodGet, "/api/negotiate_test/hello/?name=query", "", "", "text/html, application/xml;q=0.9, */*;q=0.1")
+	assertTrue(t, strings.HasPrefix(w.Header().Get("Content-Type"), "application/xml"), "xml should be negotiated, got %s", w.Header().Get("Content-Type"))
+	assertTrue(t, w.Body.String() == "<response><data><greeting>hello query</greeting></data><result>0</result></response>", "unexpected xml %s", w.Body)
+	w = send(http.MethodPost, "/api/negotiate_test/hello/", "text/csv", "name", "")
+	assertTrue(t, strings.Contains(w.Body.String(), "unsupported content type"), "unknown content type should be rejected, got %s", w.Body)
+	w = send(http.MethodGet, "/api/negotiate_test/hello/?name=query", "", "", "application/*")
+	assertTrue(t, strings.HasPrefix(w.Header().Get("Content-Type"), "application/json"), "wildcards should prefer json, got %s", w.Header().Get("Content-Type"))
+	w = send(http.MethodGet, "/api/negotiate_test/hello/?name=query", "", "", "text/*, application/xml;q=0.5")
+	assertTrue(t, strings.HasPrefix(w.Header().Get("Content-Type"), "application/xml"), "unmatched wildcards should be skipped, got %s", w.Header().Get("Content-Type"))
+}
+
+func TestNegotiatingProtocolValidatesJSON(t *testing.T) {
+	mod := NewModuleWithProtocolFactory("/api", NegotiatingApiProtocolFactory)
+	svr := NewServer()
+	svr.RegisterModule(mod.Register(&NegotiateTest{}))
+	engine := svr.GetGinEngine()
+
+	for body, expected := range map[string]string{
+		`{"name":"json","age":30,"tags":["a","b"]}`: `"greeting":"hello json"`,
+		`{"age":30}`:                               `"result":-1`,
+		`{"name":"","age":30}`:                     `"result":-1`,
+		`{"name":"json","age":200}`:                `"result":-1`,
+		`{"name":"json","age":30,"tags":["long"]}`: `"result":-1`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/negotiate_test/validate/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		assertTrue(t, strings.Contains(w.Body.String(), expected), "%s should give %s, got %s", body, expected, w.Body)
+	}
+}
@@ -0,0 +1,66 @@
+package niuhe
+
+import "github.com/ugorji/go/codec"
+
+// The Op* types implement codec.Selfer so MessagePack keeps their presence
+// semantics: unset values are encoded as nil, and nil leaves them unset.
+
+func (f OpBool) CodecEncodeSelf(e *codec.Encoder) {
+	e.MustEncode(f.value)
+}
+
+func (f *OpBool) CodecDecodeSelf(d *codec.Decoder) {
+	var value *bool
+	d.MustDecode(&value)
+	if value != nil {
+		f.Set(*value)
+	}
+}
+
+func (f OpFloat) CodecEncodeSelf(e *codec.Encoder) {
+	e.MustEncode(f.value)
+}
+
+func (f *OpFloat) CodecDecodeSelf(d *codec.Decoder) {
+	var value *float64
+	d.MustDecode(&value)
+	if value != nil {
+		f.Set(*value)
+	}
+}
+
+func (f OpInt) CodecEncodeSelf(e *codec.Encoder) {
+	e.MustEncode(f.value)
+}
+
+func (f *OpInt) CodecDecodeSelf(d *codec.Decoder) {
+	var value *int
+	d.MustDecode(&value)
+	if value != nil {
+		f.Set(*value)
+	}
+}
+
+func (f OpLong) CodecEncodeSelf(e *codec.Encoder) {
+	e.MustEncode(f.value)
+}
+
+func (f *OpLong) CodecDecodeSelf(d *codec.Decoder) {
+	var value *int64
+	d.MustDecode(&value)
+	if value != nil {
+		f.Set(*value)
+	}
+}
+
+func (f OpStr) CodecEncodeSelf(e *codec.Encoder) {
+	e.MustEncode(f.value)
+}
+
+func (f *OpStr) CodecDecodeSelf(d *codec.Decoder) {
+	var value *string
+	d.MustDecode(&value)
+	if value != nil {
+		f.Set(*value)
+	}
+}
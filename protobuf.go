@@ -0,0 +1,126 @@
+package niuhe
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+const ProtobufContentType = "application/x-protobuf"
+
+// Fields of the protobuf envelope:
+//
+//	message ApiResponse {
+//	    int64  result     = 1;
+//	    string message    = 2;
+//	    bytes  data       = 3; // the serialized response message
+//	    string request_id = 4;
+//	}
+//
+// Since an embedded message is encoded like bytes, clients may declare data
+// with the response type of a route instead.
+const (
+	protobufResultField    protowire.Number = 1
+	protobufMessageField   protowire.Number = 2
+	protobufDataField      protowire.Number = 3
+	protobufRequestIDField protowire.Number = 4
+)
+
+// DecodeProtobufRequest reads the request body into reqValue, which must
+// point to a proto-generated message.
+func DecodeProtobufRequest(c *Context, reqValue reflect.Value) error {
+	msg, ok := reqValue.Interface().(proto.Message)
+	if !ok {
+		return NewCommError(-1, fmt.Sprintf("%s is not a protobuf message", reqValue.Type()))
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return NewCommError(-1, err.Error())
+	}
+	if err := proto.Unmarshal(body, msg); err != nil {
+		return NewCommError(-1, err.Error())
+	}
+	return nil
+}
+
+// EncodeProtobufResponse serializes the envelope built by the API protocols,
+// or a custom root response, which must then be a proto-generated message.
+// Renamed envelope fields keep their numbers; extra fields other than
+// request_id are dropped.
+func EncodeProtobufResponse(c *Context, body interface{}) ([]byte, error) {
+	env, ok := body.(map[string]interface{})
+	if !ok {
+		msg, ok := body.(proto.Message)
+		if !ok {
+			return nil, fmt.Errorf("%T is not a protobuf message", body)
+		}
+		return proto.Marshal(msg)
+	}
+	names := c.envelope()
+	var buf []byte
+	if result, _ := env[names.ResultField].(int); result != 0 {
+		buf = protowire.AppendTag(buf, protobufResultField, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, uint64(int64(result)))
+	}
+	if message, _ := env[names.MessageField].(string); message != "" {
+		buf = protowire.AppendTag(buf, protobufMessageField, protowire.BytesType)
+		buf = protowire.AppendString(buf, message)
+	}
+	if data, exists := env[names.DataField]; exists && data != nil {
+		msg, ok := data.(proto.Message)
+		if !ok {
+			return nil, fmt.Errorf("%T is not a protobuf message", data)
+		}
+		dataBytes, err := proto.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		buf = protowire.AppendTag(buf, protobufDataField, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, dataBytes)
+	}
+	if requestID, _ := env["request_id"].(string); requestID != "" {
+		buf = protowire.AppendTag(buf, protobufRequestIDField, protowire.BytesType)
+		buf = protowire.AppendString(buf, requestID)
+	}
+	return buf, nil
+}
+
+var protoMessageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+
+// isProtobufResponse tells whether EncodeProtobufResponse can write the
+// responses of rspType.
+func isProtobufResponse(rspType reflect.Type) bool {
+	return reflect.PtrTo(rspType).Implements(protoMessageType)
+}
+
+type protobufApiProtocol struct{}
+
+func (self protobufApiProtocol) Read(c *Context, reqValue reflect.Value) error {
+	return DecodeProtobufRequest(c, reqValue)
+}
+
+func (self protobufApiProtocol) Write(c *Context, rsp reflect.Value, err error) error {
+	data, encodeErr := EncodeProtobufResponse(c, buildEnvelope(c, rsp, err))
+	if encodeErr != nil {
+		return encodeErr
+	}
+	c.Data(200, ProtobufContentType, data)
+	return nil
+}
+
+var protobufApiProtocolInstance protobufApiProtocol
+
+// ProtobufApiProtocolFactory reads and writes application/x-protobuf bodies.
+// Request and response types of its routes must be proto-generated messages.
+// NegotiatingApiProtocolFactory also speaks protobuf to clients asking for it.
+var ProtobufApiProtocolFactory = ApiProtocolFactoryFunc(func() IApiProtocol {
+	return &protobufApiProtocolInstance
+})
+
+func init() {
+	RegisterRequestDecoder(ProtobufContentType, DecodeProtobufRequest)
+	RegisterResponseEncoderFor(ProtobufContentType, EncodeProtobufResponse, isProtobufResponse)
+}
@@ -0,0 +1,91 @@
+package niuhe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type ProtoTest struct{}
+
+func (api *ProtoTest) Echo_POST(c *Context, req *wrapperspb.StringValue, rsp *wrapperspb.StringValue) error {
+	if req.Value == "" {
+		return NewCommError(2, "empty value")
+	}
+	rsp.Value = "echo " + req.Value
+	return nil
+}
+
+func decodeProtobufEnvelope(t *testing.T, body []byte) (result int64, message string, data []byte) {
+	for len(body) > 0 {
+		num, typ, n := protowire.ConsumeTag(body)
+		body = body[n:]
+		switch {
+		case num == protobufResultField && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(body)
+			result, body = int64(v), body[n:]
+		case num == protobufMessageField:
+			v, n := protowire.ConsumeString(body)
+			message, body = v, body[n:]
+		case num == protobufDataField:
+			v, n := protowire.ConsumeBytes(body)
+			data, body = v, body[n:]
+		default:
+			t.Fatalf("unexpected field %d", num)
+		}
+	}
+	return
+}
+
+func TestProtobufProtocol(t *testing.T) {
+	svr := NewServer()
+	svr.RegisterModule(NewModuleWithProtocolFactory("/api", ProtobufApiProtocolFactory).Register(&ProtoTest{}))
+	engine := svr.GetGinEngine()
+
+	post := func(value string) []byte {
+		body, _ := proto.Marshal(wrapperspb.String(value))
+		req := httptest.NewRequest(http.MethodPost, "/api/proto_test/echo/", strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", ProtobufContentType)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		assertTrue(t, w.Header().Get("Content-Type") == ProtobufContentType, "unexpected content type %s", w.Header().Get("Content-Type"))
+		return w.Body.Bytes()
+	}
+
+	result, _, data := decodeProtobufEnvelope(t, post("hi"))
+	var rsp wrapperspb.StringValue
+	if err := proto.Unmarshal(data, &rsp); err != nil {
+		t.Fatal(err)
+	}
+	assertTrue(t, result == 0 && rsp.Value == "echo hi", "unexpected response %d %q", result, rsp.Value)
+
+	result, message, data := decodeProtobufEnvelope(t, post(""))
+	assertTrue(t, result == 2 && message == "empty value" && data == nil, "unexpected error response %d %q %v", result, message, data)
+}
+
+func TestNegotiatedProtobuf(t *testing.T) {
+	mod := NewModuleWithProtocolFactory("/api", NegotiatingApiProtocolFactory)
+	svr := NewServer()
+	svr.RegisterModule(mod.Register(&ProtoTest{}).Register(&NegotiateTest{}))
+	engine := svr.GetGinEngine()
+
+	body, _ := proto.Marshal(wrapperspb.String("hi"))
+	req := httptest.NewRequest(http.MethodPost, "/api/proto_test/echo/", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", ProtobufContentType)
+	req.Header.Set("Accept", ProtobufContentType)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	assertTrue(t, w.Header().Get("Content-Type") == ProtobufContentType, "protobuf should be negotiated, got %s", w.Header().Get("Content-Type"))
+
+	req = httptest.NewRequest(http.MethodGet, "/api/negotiate_test/hello/?name=pb", nil)
+	req.Header.Set("Accept", ProtobufContentType)
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	assertTrue(t, w.Code == http.StatusOK && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json"), "routes without protobuf messages should fall back to json, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	assertTrue(t, strings.Contains(w.Body.String(), `"greeting":"hello pb"`), "unexpected response %s", w.Body)
+}
@@ -1,6 +1,7 @@
 package niuhe
 
 import (
+	"encoding/json"
 	"reflect"
 
 	"github.com/ziipin-server/zpform"
@@ -25,34 +26,11 @@ func (self DefaultApiProtocol) Read(c *Context, reqValue reflect.Value) error {
 }
 
 func (self DefaultApiProtocol) Write(c *Context, rsp reflect.Value, err error) error {
-	rspInst := rsp.Interface()
-	if _, ok := rspInst.(isCustomRoot); ok {
-		c.JSON(200, rspInst)
-	} else {
-		var response map[string]interface{}
-		if err != nil {
-			if commErr, ok := err.(ICommError); ok {
-				response = map[string]interface{}{
-					"result":  commErr.GetCode(),
-					"message": commErr.GetMessage(),
-				}
-				if commErr.GetCode() == 0 {
-					response["data"] = rsp.Interface()
-				}
-			} else {
-				response = map[string]interface{}{
-					"result":  -1,
-					"message": err.Error(),
-				}
-			}
-		} else {
-			response = map[string]interface{}{
-				"result": 0,
-				"data":   rspInst,
-			}
-		}
-		c.JSON(200, response)
+	data, encodeErr := json.Marshal(buildEnvelope(c, rsp, err))
+	if encodeErr != nil {
+		return encodeErr
 	}
+	c.Data(200, "application/json; charset=utf-8", data)
 	return nil
 }
 
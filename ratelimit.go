@@ -0,0 +1,228 @@
+package niuhe
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimitState is the per-key state kept by a RateLimitStore.
+type RateLimitState struct {
+	Count float64   // tokens left (token bucket) or hits in the current window (sliding window)
+	Prev  float64   // hits in the previous window (sliding window)
+	Stamp time.Time // last refill (token bucket) or start of the current window (sliding window)
+}
+
+// RateLimitStore keeps rate limit states. Implementations backed by a shared
+// database let several instances enforce the same limits.
+type RateLimitStore interface {
+	// Update runs fn on the state of key atomically and keeps the state for
+	// at least ttl after this call.
+	Update(key string, ttl time.Duration, fn func(state *RateLimitState)) error
+}
+
+type memoryRateLimitEntry struct {
+	lock     sync.Mutex
+	state    RateLimitState
+	expireAt time.Time
+}
+
+type MemoryRateLimitStore struct {
+	lock      sync.Mutex
+	entries   map[string]*memoryRateLimitEntry
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		entries:   make(map[string]*memoryRateLimitEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryRateLimitStore) Update(key string, ttl time.Duration, fn func(state *RateLimitState)) error {
+	now := time.Now()
+	s.lock.Lock()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, entry := range s.entries {
+			if now.After(entry.expireAt) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	entry, exists := s.entries[key]
+	if !exists || now.After(entry.expireAt) {
+		entry = &memoryRateLimitEntry{}
+		s.entries[key] = entry
+	}
+	entry.expireAt = now.Add(ttl)
+	s.lock.Unlock()
+
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+	fn(&entry.state)
+	return nil
+}
+
+type RateLimitAlgorithm interface {
+	// Take consumes one request from state and tells whether it is allowed
+	// and, if not, how long the client should wait before retrying.
+	Take(state *RateLimitState, now time.Time) (allowed bool, retryAfter time.Duration)
+	// StateTTL is how long an idle state has to be kept.
+	StateTTL() time.Duration
+}
+
+type tokenBucket struct {
+	rate  float64
+	burst float64
+}
+
+// TokenBucket allows bursts of up to burst requests, refilled at rate tokens per second.
+func TokenBucket(rate float64, burst int) RateLimitAlgorithm {
+	if rate <= 0 || burst <= 0 {
+		panic("TokenBucket: rate and burst must be positive")
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst)}
+}
+
+func (tb *tokenBucket) Take(state *RateLimitState, now time.Time) (bool, time.Duration) {
+	if state.Stamp.IsZero() {
+		state.Count = tb.burst
+	} else if elapsed := now.Sub(state.Stamp).Seconds(); elapsed > 0 {
+		state.Count = math.Min(tb.burst, state.Count+elapsed*tb.rate)
+	}
+	state.Stamp = now
+	if state.Count >= 1 {
+		state.Count--
+		return true, 0
+	}
+	return false, time.Duration((1 - state.Count) / tb.rate * float64(time.Second))
+}
+
+func (tb *tokenBucket) StateTTL() time.Duration {
+	return time.Duration(tb.burst/tb.rate*float64(time.Second)) + time.Second
+}
+
+type slidingWindow struct {
+	limit  float64
+	window time.Duration
+}
+
+// SlidingWindow allows limit requests in any window, approximated by
+// weighting the previous fixed window.
+func SlidingWindow(limit int, window time.Duration) RateLimitAlgorithm {
+	if limit <= 0 || window <= 0 {
+		panic("SlidingWindow: limit and window must be positive")
+	}
+	return &slidingWindow{limit: float64(limit), window: window}
+}
+
+func (sw *slidingWindow) Take(state *RateLimitState, now time.Time) (bool, time.Duration) {
+	start := now.Truncate(sw.window)
+	if !state.Stamp.Equal(start) {
+		if start.Sub(state.Stamp) == sw.window {
+			state.Prev = state.Count
+		} else {
+			state.Prev = 0
+		}
+		state.Count = 0
+		state.Stamp = start
+	}
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(sw.window)
+	if state.Prev*weight+state.Count+1 <= sw.limit {
+		state.Count++
+		return true, 0
+	}
+	if state.Count+1 > sw.limit || state.Prev == 0 {
+		return false, sw.window - elapsed
+	}
+	// wait until the weight of the previous window has dropped enough
+	needWeight := (sw.limit - state.Count - 1) / state.Prev
+	return false, time.Duration((1-needWeight)*float64(sw.window)) - elapsed
+}
+
+func (sw *slidingWindow) StateTTL() time.Duration {
+	return 2 * sw.window
+}
+
+type RateLimitKeyFunc func(*Context) string
+
+func RateLimitByRoute(c *Context) string {
+	return c.RoutePath()
+}
+
+func RateLimitByIP(c *Context) string {
+	return c.ClientIP()
+}
+
+func RateLimitByRouteAndIP(c *Context) string {
+	return c.RoutePath() + "|" + c.ClientIP()
+}
+
+// RateLimitBySession keys by a session value such as the user id; it needs SessionMiddleware.
+func RateLimitBySession(sessionKey string) RateLimitKeyFunc {
+	return func(c *Context) string {
+		return fmt.Sprint(c.GetSession(sessionKey))
+	}
+}
+
+type RateLimitConfig struct {
+	// Name namespaces the keys of this limiter in the store. Set it when a
+	// store is shared between instances.
+	Name      string
+	Algorithm RateLimitAlgorithm
+	Key       RateLimitKeyFunc // defaults to RateLimitByIP
+	Store     RateLimitStore   // defaults to a new MemoryRateLimitStore
+	Error     error            // returned through the route protocol when limited, see ErrCodeTooManyRequests
+}
+
+// ErrCodeTooManyRequests is the code of the default RateLimitConfig.Error.
+// REST routes answer it with status 429 after
+//
+//	niuhe.RegisterErrorStatus(niuhe.ErrCodeTooManyRequests, http.StatusTooManyRequests)
+const ErrCodeTooManyRequests = 429
+
+var rateLimiterSeq int32
+
+// RateLimitMiddleware rejects requests exceeding cfg.Algorithm. Use it on a
+// Server, a Module or a single Register call to choose the scope.
+func RateLimitMiddleware(cfg RateLimitConfig) HandlerFunc {
+	if cfg.Algorithm == nil {
+		panic("RateLimitMiddleware: Algorithm is required")
+	}
+	if cfg.Name == "" {
+		cfg.Name = "rl" + strconv.Itoa(int(atomic.AddInt32(&rateLimiterSeq, 1)))
+	}
+	if cfg.Key == nil {
+		cfg.Key = RateLimitByIP
+	}
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryRateLimitStore()
+	}
+	if cfg.Error == nil {
+		cfg.Error = NewCommError(ErrCodeTooManyRequests, "too many requests")
+	}
+	ttl := cfg.Algorithm.StateTTL()
+	return func(c *Context) {
+		var allowed bool
+		var retryAfter time.Duration
+		key := cfg.Name + ":" + cfg.Key(c)
+		if err := cfg.Store.Update(key, ttl, func(state *RateLimitState) {
+			allowed, retryAfter = cfg.Algorithm.Take(state, time.Now())
+		}); err != nil {
+			c.Logger().Error("rate limit store update %s failed: %v", key, err)
+			allowed = true
+		}
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithApiError(cfg.Error)
+			return
+		}
+		c.Next()
+	}
+}
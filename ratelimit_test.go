@@ -0,0 +1,50 @@
+package niuhe
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tb := TokenBucket(1, 2)
+	var state RateLimitState
+	now := time.Now()
+	ok1, _ := tb.Take(&state, now)
+	ok2, _ := tb.Take(&state, now)
+	ok3, retry := tb.Take(&state, now)
+	assertTrue(t, ok1 && ok2 && !ok3, "burst of 2 expected, got %v %v %v", ok1, ok2, ok3)
+	assertTrue(t, retry == time.Second, "retry after should be 1s, got %v", retry)
+	ok4, _ := tb.Take(&state, now.Add(time.Second))
+	assertTrue(t, ok4, "token should be refilled after 1s")
+}
+
+func TestSlidingWindow(t *testing.T) {
+	sw := SlidingWindow(2, time.Minute)
+	var state RateLimitState
+	start := time.Now().Truncate(time.Minute)
+	ok1, _ := sw.Take(&state, start)
+	ok2, _ := sw.Take(&state, start.Add(time.Second))
+	ok3, _ := sw.Take(&state, start.Add(2*time.Second))
+	assertTrue(t, ok1 && ok2 && !ok3, "limit of 2 expected, got %v %v %v", ok1, ok2, ok3)
+	ok4, _ := sw.Take(&state, start.Add(time.Minute+10*time.Second))
+	assertTrue(t, !ok4, "previous window should still weigh in")
+	ok5, _ := sw.Take(&state, start.Add(time.Minute+40*time.Second))
+	assertTrue(t, ok5, "previous window weight should have decayed")
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	mod := NewModule("/api")
+	mod.Use(RateLimitMiddleware(RateLimitConfig{Algorithm: TokenBucket(0.001, 1), Key: RateLimitByRoute}))
+	mod.Register(&IdemTest{})
+	svr := NewServer()
+	svr.RegisterModule(mod)
+	engine := svr.GetGinEngine()
+
+	first := doRequest(engine, http.MethodPost, "/api/idem_test/create/", nil)
+	second := doRequest(engine, http.MethodPost, "/api/idem_test/create/", nil)
+	assertTrue(t, strings.Contains(first.Body.String(), `"result":0`), "first call should pass, got %s", first.Body)
+	assertTrue(t, strings.Contains(second.Body.String(), `"result":429`), "second call should be limited, got %s", second.Body)
+	assertTrue(t, second.Header().Get("Retry-After") != "", "limited response should carry Retry-After")
+}
@@ -0,0 +1,54 @@
+package niuhe
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseRecorder keeps a copy of everything written to the response so that
+// middlewares can store and replay it later.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func newResponseRecorder(w gin.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w}
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// StoredResponse is a response captured by a middleware for later replay.
+type StoredResponse struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+	// RequestHash identifies the request answered, see IdempotencyMiddleware.
+	RequestHash string `json:"request_hash,omitempty"`
+}
+
+func (w *responseRecorder) stored() *StoredResponse {
+	return &StoredResponse{
+		Status:      w.Status(),
+		ContentType: w.Header().Get("Content-Type"),
+		Body:        append([]byte(nil), w.body.Bytes()...),
+	}
+}
+
+func (rsp *StoredResponse) replay(c *Context) {
+	status := rsp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	c.Data(status, rsp.ContentType, rsp.Body)
+}
@@ -0,0 +1,82 @@
+package niuhe
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	requestIDKey       = "niuhe.request_id"
+	maxRequestIDLength = 128
+)
+
+type RequestIDOptions struct {
+	Header    string        // defaults to "X-Request-Id"
+	Generator func() string // defaults to 32 random hex digits
+}
+
+func newRequestID() string {
+	var buf [16]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDMiddleware accepts the request ID sent by the client, or generates
+// one, and echoes it in the response headers. To return it in the envelope
+// too, add EnvelopeRequestID to the Extra of the EnvelopeConfig of a Module.
+func RequestIDMiddleware(opts RequestIDOptions) gin.HandlerFunc {
+	if opts.Header == "" {
+		opts.Header = "X-Request-Id"
+	}
+	if opts.Generator == nil {
+		opts.Generator = newRequestID
+	}
+	return func(c *gin.Context) {
+		id := c.GetHeader(opts.Header)
+		if !validRequestID(id) {
+			id = opts.Generator()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(opts.Header, id)
+		c.Next()
+	}
+}
+
+// EnableRequestID installs RequestIDMiddleware in front of every route.
+func (svr *Server) EnableRequestID(opts ...RequestIDOptions) {
+	var o RequestIDOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	svr.requestID = RequestIDMiddleware(o)
+}
+
+// RequestID returns the ID set by RequestIDMiddleware, or "".
+func (c *Context) RequestID() string {
+	return c.GetString(requestIDKey)
+}
+
+// Logger returns the default logger prefixing every line with the request ID.
+// It is the only way to get the ID in a log line: the package level LogXxx
+// functions know nothing about the request. niuhe logs everything about a
+// request through it, and handlers and middlewares should do the same.
+func (c *Context) Logger() *LoggerT {
+	if id := c.RequestID(); id != "" {
+		return defaultLogger.WithPrefix("[" + id + "] ")
+	}
+	return defaultLogger
+}
@@ -0,0 +1,52 @@
+package niuhe
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	mod := NewModule("/api")
+	mod.SetEnvelope(EnvelopeConfig{
+		Extra: map[string]func(*Context, error) interface{}{"request_id": EnvelopeRequestID},
+	})
+	mod.Register(&IdemTest{})
+	svr := NewServer()
+	svr.RegisterModule(mod)
+	svr.EnableRequestID()
+	engine := svr.GetGinEngine()
+
+	w := doRequest(engine, http.MethodPost, "/api/idem_test/create/", map[string]string{"X-Request-Id": "abc"})
+	assertTrue(t, w.Header().Get("X-Request-Id") == "abc", "incoming request id should be kept")
+	assertTrue(t, strings.Contains(w.Body.String(), `"request_id":"abc"`), "envelope should carry the request id, got %s", w.Body)
+
+	w = doRequest(engine, http.MethodPost, "/api/idem_test/create/", nil)
+	assertTrue(t, len(w.Header().Get("X-Request-Id")) == 32, "request id should be generated, got %q", w.Header().Get("X-Request-Id"))
+}
+
+func TestLoggerWithPrefix(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, "")
+	derived := logger.WithPrefix("[rid%] ")
+	derived.Info("hello %d", 1)
+	derived.Debug("hidden")
+	assertTrue(t, strings.Contains(buf.String(), "[rid%] hello 1"), "prefix should be written, got %s", buf.String())
+	assertTrue(t, strings.Contains(buf.String(), "requestid_test.go"), "caller should be the test file, got %s", buf.String())
+	assertTrue(t, !strings.Contains(buf.String(), "hidden"), "derived logger should follow the parent level")
+}
+
+func TestDerivedLoggerSetters(t *testing.T) {
+	var buf, other bytes.Buffer
+	logger := NewLogger(&buf, "")
+	derived := logger.WithPrefix("[rid] ")
+	derived.SetOutput(&other)
+	derived.SetLogLevel(LOG_DEBUG)
+	var called []string
+	derived.AddCallback(LOG_DEBUG, func(msg string) { called = append(called, msg) })
+	logger.Debug("from parent")
+	assertTrue(t, buf.Len() == 0, "output should be changed on the parent, got %s", buf.String())
+	assertTrue(t, strings.Contains(other.String(), "from parent"), "level should be changed on the parent, got %s", other.String())
+	assertTrue(t, len(called) == 1 && called[0] == "from parent", "callback should be added to the parent, got %v", called)
+}
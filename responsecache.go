@@ -0,0 +1,196 @@
+package niuhe
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type responseCacheEntry struct {
+	key      string
+	rsp      *StoredResponse
+	expireAt time.Time
+	tags     []string
+}
+
+// ResponseCache is an in-memory LRU cache of serialized API responses.
+type ResponseCache struct {
+	lock       sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	ll         *list.List
+	entries    map[string]*list.Element
+	tags       map[string]map[string]struct{}
+}
+
+// NewResponseCache creates a cache holding at most maxEntries responses
+// (0 means unlimited), each kept for ttl unless a route overrides it.
+func NewResponseCache(maxEntries int, ttl time.Duration) *ResponseCache {
+	return &ResponseCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		ll:         list.New(),
+		entries:    make(map[string]*list.Element),
+		tags:       make(map[string]map[string]struct{}),
+	}
+}
+
+func (rc *ResponseCache) Get(key string) (*StoredResponse, bool) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	elem, exists := rc.entries[key]
+	if !exists {
+		return nil, false
+	}
+	entry := elem.Value.(*responseCacheEntry)
+	if time.Now().After(entry.expireAt) {
+		rc.removeElement(elem)
+		return nil, false
+	}
+	rc.ll.MoveToFront(elem)
+	return entry.rsp, true
+}
+
+func (rc *ResponseCache) Set(key string, rsp *StoredResponse, ttl time.Duration, tags ...string) {
+	if ttl <= 0 {
+		ttl = rc.ttl
+	}
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	if elem, exists := rc.entries[key]; exists {
+		rc.removeElement(elem)
+	}
+	entry := &responseCacheEntry{key: key, rsp: rsp, expireAt: time.Now().Add(ttl), tags: tags}
+	rc.entries[key] = rc.ll.PushFront(entry)
+	for _, tag := range tags {
+		keys, exists := rc.tags[tag]
+		if !exists {
+			keys = make(map[string]struct{})
+			rc.tags[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	for rc.maxEntries > 0 && rc.ll.Len() > rc.maxEntries {
+		rc.removeElement(rc.ll.Back())
+	}
+}
+
+func (rc *ResponseCache) removeElement(elem *list.Element) {
+	entry := rc.ll.Remove(elem).(*responseCacheEntry)
+	delete(rc.entries, entry.key)
+	for _, tag := range entry.tags {
+		if keys, exists := rc.tags[tag]; exists {
+			delete(keys, entry.key)
+			if len(keys) == 0 {
+				delete(rc.tags, tag)
+			}
+		}
+	}
+}
+
+// InvalidateTag drops every cached response registered under one of tags.
+func (rc *ResponseCache) InvalidateTag(tags ...string) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	for _, tag := range tags {
+		for key := range rc.tags[tag] {
+			if elem, exists := rc.entries[key]; exists {
+				rc.removeElement(elem)
+			}
+		}
+	}
+}
+
+func (rc *ResponseCache) Purge() {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	rc.ll.Init()
+	rc.entries = make(map[string]*list.Element)
+	rc.tags = make(map[string]map[string]struct{})
+}
+
+func (rc *ResponseCache) Len() int {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	return rc.ll.Len()
+}
+
+type CacheOptions struct {
+	TTL         time.Duration // overrides the cache default when > 0
+	Headers     []string      // request headers that are part of the key
+	SessionKeys []string      // session values that are part of the key (needs SessionMiddleware)
+	Tags        []string
+	// TagFunc adds tags computed from the decoded request struct.
+	TagFunc func(c *Context, req interface{}) []string
+}
+
+// Middleware caches successful responses of _GET APIs. The key is derived from
+// the request struct decoded by the route protocol, which the handler then
+// receives without decoding the request again, plus the selected headers
+// and session values. Hits skip the middlewares registered after it, so put
+// it behind authentication.
+func (rc *ResponseCache) Middleware(opts CacheOptions) HandlerFunc {
+	return func(c *Context) {
+		if c.Request.Method != http.MethodGet || c.reqType == nil || c.protocol == nil {
+			c.Next()
+			return
+		}
+		req, err := c.readRequest()
+		if err != nil {
+			c.Next() // let the API report the read error itself
+			return
+		}
+		key, err := rc.makeKey(c, req.Interface(), opts)
+		if err != nil {
+			c.Logger().Warn("response cache %s: cannot build key: %v", c.RoutePath(), err)
+			c.Next()
+			return
+		}
+		if stored, hit := rc.Get(key); hit {
+			c.Header("X-Cache", "HIT")
+			stored.replay(c)
+			c.Abort()
+			return
+		}
+		writer := c.Writer
+		recorder := newResponseRecorder(writer)
+		c.Writer = recorder
+		c.Header("X-Cache", "MISS")
+		defer func() {
+			c.Writer = writer
+		}()
+		c.Next()
+		if code, _, ok := c.ApiResult(); !ok || code != 0 || recorder.Status() != http.StatusOK {
+			return
+		}
+		tags := opts.Tags
+		if opts.TagFunc != nil {
+			tags = append(append([]string{}, tags...), opts.TagFunc(c, req.Interface())...)
+		}
+		rc.Set(key, recorder.stored(), opts.TTL, tags...)
+	}
+}
+
+func (rc *ResponseCache) makeKey(c *Context, req interface{}, opts CacheOptions) (string, error) {
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	sb.WriteString(c.RoutePath())
+	sb.WriteByte(0)
+	sb.Write(reqBytes)
+	for _, name := range opts.Headers {
+		sb.WriteByte(0)
+		sb.WriteString(c.GetHeader(name))
+	}
+	for _, name := range opts.SessionKeys {
+		sb.WriteByte(0)
+		fmt.Fprint(&sb, c.GetSession(name))
+	}
+	return sb.String(), nil
+}
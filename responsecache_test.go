@@ -0,0 +1,74 @@
+package niuhe
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type cacheTestReq struct {
+	Id int `json:"id"`
+}
+
+type cacheTestRsp struct {
+	Id    int `json:"id"`
+	Calls int `json:"calls"`
+}
+
+type CacheTest struct {
+	calls int
+}
+
+func (api *CacheTest) Item_GET(c *Context, req *cacheTestReq, rsp *cacheTestRsp) error {
+	api.calls++
+	rsp.Id, rsp.Calls = req.Id, api.calls
+	return nil
+}
+
+func TestResponseCache(t *testing.T) {
+	api := &CacheTest{}
+	cache := NewResponseCache(1, time.Minute)
+	mod := NewModule("/api")
+	mod.Register(api, cache.Middleware(CacheOptions{Tags: []string{"items"}}))
+	svr := NewServer()
+	svr.RegisterModule(mod)
+	engine := svr.GetGinEngine()
+
+	first := doRequest(engine, http.MethodGet, "/api/cache_test/item/?id=1", nil)
+	second := doRequest(engine, http.MethodGet, "/api/cache_test/item/?id=1", nil)
+	assertTrue(t, api.calls == 1, "second call should hit the cache, calls=%d", api.calls)
+	assertTrue(t, first.Body.String() == second.Body.String(), "cached body differs")
+	assertTrue(t, second.Header().Get("X-Cache") == "HIT", "second call should be a cache hit")
+
+	doRequest(engine, http.MethodGet, "/api/cache_test/item/?id=2", nil)
+	doRequest(engine, http.MethodGet, "/api/cache_test/item/?id=1", nil)
+	assertTrue(t, api.calls == 3, "id=1 should be evicted by LRU, calls=%d", api.calls)
+
+	cache.InvalidateTag("items")
+	assertTrue(t, cache.Len() == 0, "invalidated cache should be empty, len=%d", cache.Len())
+}
+
+type countingProtocol struct {
+	DefaultApiProtocol
+	reads int
+}
+
+func (p *countingProtocol) Read(c *Context, reqValue reflect.Value) error {
+	p.reads++
+	return p.DefaultApiProtocol.Read(c, reqValue)
+}
+
+func TestResponseCacheReadsRequestOnce(t *testing.T) {
+	protocol := &countingProtocol{}
+	pf := ApiProtocolFactoryFunc(func() IApiProtocol { return protocol })
+	mod := NewModuleWithProtocolFactory("/api", pf)
+	mod.Register(&CacheTest{}, NewResponseCache(10, time.Minute).Middleware(CacheOptions{}))
+	svr := NewServer()
+	svr.RegisterModule(mod)
+
+	w := doRequest(svr.GetGinEngine(), http.MethodGet, "/api/cache_test/item/?id=5", nil)
+	assertTrue(t, protocol.reads == 1, "request should be decoded once, got %d reads", protocol.reads)
+	assertTrue(t, strings.Contains(w.Body.String(), `"id":5`), "handler should get the decoded request, got %s", w.Body)
+}
@@ -0,0 +1,107 @@
+package niuhe
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// badRequestError marks errors of reading the request.
+type badRequestError struct {
+	err error
+}
+
+func (e badRequestError) Error() string {
+	return e.err.Error()
+}
+
+func (e badRequestError) GetCode() int {
+	code, _ := resultOf(e.err)
+	return code
+}
+
+func (e badRequestError) GetMessage() string {
+	_, message := resultOf(e.err)
+	return message
+}
+
+// Problem is the RFC 7807 body written by RestApiProtocolFactory for errors.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      int    `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// errorStatus returns the HTTP status of a failed API call: 400 for requests
+// that could not be read, the status registered for the code of CommErrors
+// or 400 if there is none, and 500 for other errors.
+func errorStatus(err error) int {
+	switch e := err.(type) {
+	case badRequestError:
+		return http.StatusBadRequest
+	case ICommError:
+		if status, exists := errorStatuses[e.GetCode()]; exists {
+			return status
+		}
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+type restApiProtocol struct{}
+
+func (self restApiProtocol) Read(c *Context, reqValue reflect.Value) error {
+	if err := negotiatingApiProtocolInstance.Read(c, reqValue); err != nil {
+		return badRequestError{err}
+	}
+	return nil
+}
+
+func (self restApiProtocol) Write(c *Context, rsp reflect.Value, err error) error {
+	code, message := resultOf(err)
+	if code == 0 {
+		data, encodeErr := json.Marshal(rsp.Interface())
+		if encodeErr != nil {
+			return encodeErr
+		}
+		c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+		return nil
+	}
+	status := errorStatus(err)
+	if status >= http.StatusInternalServerError {
+		// keep internal details out of the response
+		c.Logger().Error("%s failed: %v", c.RoutePath(), err)
+		message = "internal server error"
+	}
+	data, encodeErr := json.Marshal(&Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    message,
+		Instance:  c.Request.URL.Path,
+		Code:      code,
+		RequestID: c.RequestID(),
+	})
+	if encodeErr != nil {
+		return encodeErr
+	}
+	c.Data(status, ProblemContentType, data)
+	return nil
+}
+
+var restApiProtocolInstance restApiProtocol
+
+// RestApiProtocolFactory reads requests like NegotiatingApiProtocolFactory
+// and writes the response itself as JSON on success. Errors are written as
+// application/problem+json with the status registered with
+// RegisterErrorStatus, so HTTP tooling and caches see the failures. The
+// detail of 5xx problems is generic; the error itself is logged.
+var RestApiProtocolFactory = ApiProtocolFactoryFunc(func() IApiProtocol {
+	return &restApiProtocolInstance
+})
@@ -0,0 +1,59 @@
+package niuhe
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type RestTest struct{}
+
+func (api *RestTest) Item_GET(c *Context, req *rpcTestReq, rsp *rpcTestRsp) error {
+	switch req.A {
+	case 404:
+		return NewCommError(40401, "item not found")
+	case 500:
+		return errors.New("db is down")
+	}
+	rsp.Sum = req.A
+	return nil
+}
+
+func TestRestProtocol(t *testing.T) {
+	RegisterErrorStatus(40401, http.StatusNotFound)
+	t.Cleanup(func() { delete(errorStatuses, 40401) })
+	svr := NewServer()
+	svr.RegisterModule(NewModuleWithProtocolFactory("/api", RestApiProtocolFactory).Register(&RestTest{}))
+	engine := svr.GetGinEngine()
+
+	w := doRequest(engine, http.MethodGet, "/api/rest_test/item/?a=3", nil)
+	assertTrue(t, w.Code == 200 && w.Body.String() == `{"sum":3}`, "success should write the response only, got %d %s", w.Code, w.Body)
+
+	for query, status := range map[string]int{"a=404": 404, "a=500": 500, "a=x": 400} {
+		w = doRequest(engine, http.MethodGet, "/api/rest_test/item/?"+query, nil)
+		var problem Problem
+		json.Unmarshal(w.Body.Bytes(), &problem)
+		assertTrue(t, w.Code == status && problem.Status == status, "%s should get status %d, got %d %s", query, status, w.Code, w.Body)
+		assertTrue(t, w.Header().Get("Content-Type") == ProblemContentType, "%s should get a problem, got %s", query, w.Header().Get("Content-Type"))
+		assertTrue(t, problem.Instance == "/api/rest_test/item/", "unexpected instance %s", problem.Instance)
+		if status == 500 {
+			assertTrue(t, !strings.Contains(problem.Detail, "db is down"), "5xx should not leak the error, got %s", w.Body)
+		}
+	}
+}
+
+func TestRestRateLimitStatus(t *testing.T) {
+	RegisterErrorStatus(ErrCodeTooManyRequests, http.StatusTooManyRequests)
+	t.Cleanup(func() { delete(errorStatuses, ErrCodeTooManyRequests) })
+	svr := NewServer()
+	limit := RateLimitMiddleware(RateLimitConfig{Algorithm: SlidingWindow(1, time.Minute)})
+	svr.RegisterModule(NewModuleWithProtocolFactory("/api", RestApiProtocolFactory).Register(&RestTest{}, limit))
+	engine := svr.GetGinEngine()
+
+	doRequest(engine, http.MethodGet, "/api/rest_test/item/?a=1", nil)
+	w := doRequest(engine, http.MethodGet, "/api/rest_test/item/?a=1", nil)
+	assertTrue(t, w.Code == http.StatusTooManyRequests, "rate limited calls should get 429, got %d %s", w.Code, w.Body)
+}
@@ -36,6 +36,10 @@ func (sc *_SessCtrl) Save(c *Context) error {
 	if !sc.Modified || sc.Session == nil {
 		return nil
 	}
+	if call := jsonrpcCallOf(c.Context); call != nil {
+		call.session = sc // saved once after the batch
+		return nil
+	}
 	if err := sc.Store.Save(c.Request, c.Writer, sc.Session); err != nil {
 		return err
 	}
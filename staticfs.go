@@ -0,0 +1,164 @@
+package niuhe
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultImmutablePattern matches fingerprinted names like app.3f9a2c1b.js or chunk-5d41402a.css.
+var DefaultImmutablePattern = regexp.MustCompile(`[.-][0-9a-fA-F]{8,}\.[A-Za-z0-9]+$`)
+
+type StaticFSOptions struct {
+	Index string // defaults to "index.html"
+	// SPAFallback serves Index for missing paths without a file extension,
+	// so that client-side routes of single-page apps can be reloaded.
+	SPAFallback bool
+	// Immutable matches fingerprinted files served with a long max-age,
+	// defaults to DefaultImmutablePattern. Other files must be revalidated.
+	Immutable *regexp.Regexp
+	MaxAge    time.Duration // max-age of immutable files, defaults to one year
+	// Precompressed serves name.gz instead of name to clients accepting gzip.
+	Precompressed bool
+}
+
+// StaticFS serves the files of fsys, e.g. an embed.FS, under relativePath.
+// Mounted at "/", it serves the paths no route matches.
+func (svr *Server) StaticFS(relativePath string, fsys fs.FS, opts ...StaticFSOptions) {
+	var o StaticFSOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.Index == "" {
+		o.Index = "index.html"
+	}
+	if o.Immutable == nil {
+		o.Immutable = DefaultImmutablePattern
+	}
+	if o.MaxAge <= 0 {
+		o.MaxAge = 365 * 24 * time.Hour
+	}
+	svr.staticPaths = append(svr.staticPaths, staticPath{relativePath: relativePath, fs: &staticFS{fsys: fsys, opts: o}})
+}
+
+type staticFS struct {
+	fsys fs.FS
+	opts StaticFSOptions
+	// etags are computed once per file since fsys does not change; only
+	// existing files are stored, so it is bounded by the size of fsys.
+	etags sync.Map // name => etag
+}
+
+type staticFile struct {
+	name    string
+	content io.ReadSeeker
+	modTime time.Time
+	etag    string
+	closer  io.Closer
+}
+
+func (sf *staticFS) open(name string) (*staticFile, error) {
+	f, err := sf.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return sf.open(path.Join(name, sf.opts.Index))
+	}
+	file := &staticFile{name: name, modTime: info.ModTime(), closer: f}
+	if content, ok := f.(io.ReadSeeker); ok {
+		file.content = content
+	} else {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		file.content = bytes.NewReader(data)
+	}
+	if etag, ok := sf.etags.Load(name); ok {
+		file.etag = etag.(string)
+		return file, nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file.content); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := file.content.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	file.etag = `"` + hex.EncodeToString(hash.Sum(nil)[:12]) + `"`
+	sf.etags.Store(name, file.etag)
+	return file, nil
+}
+
+// handle serves the file named by the filepath parameter of the route.
+func (sf *staticFS) handle(c *gin.Context) {
+	sf.serve(c, c.Param("filepath"))
+}
+
+// handleNoRoute serves a StaticFS mounted at "/", which cannot have a route
+// of its own next to the modules.
+func (sf *staticFS) handleNoRoute(c *gin.Context) {
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		sf.serve(c, c.Request.URL.Path)
+	}
+}
+
+func (sf *staticFS) serve(c *gin.Context, name string) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "."
+	}
+	file, err := sf.open(name)
+	if errors.Is(err, fs.ErrNotExist) && sf.opts.SPAFallback && path.Ext(name) == "" {
+		file, err = sf.open(sf.opts.Index)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	} else if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	defer func() { file.closer.Close() }()
+	header := c.Writer.Header()
+	if sf.opts.Precompressed {
+		header.Add("Vary", "Accept-Encoding")
+		if strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+			if gzFile, err := sf.open(file.name + ".gz"); err == nil {
+				header.Set("Content-Encoding", "gzip")
+				gzFile.name = file.name
+				file.closer.Close()
+				file = gzFile
+			}
+		}
+	}
+	if sf.opts.Immutable.MatchString(file.name) {
+		header.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(sf.opts.MaxAge.Seconds()))+", immutable")
+	} else {
+		header.Set("Cache-Control", "no-cache")
+	}
+	header.Set("ETag", file.etag)
+	http.ServeContent(c.Writer, c.Request, file.name, file.modTime, file.content)
+}
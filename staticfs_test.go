@@ -0,0 +1,68 @@
+package niuhe
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStaticFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":         {Data: []byte("<html>app</html>")},
+		"app.3f9a2c1b.js":    {Data: []byte("console.log(1)")},
+		"app.3f9a2c1b.js.gz": {Data: []byte("gzipped")},
+		"assets/logo.svg":    {Data: []byte("<svg/>")},
+	}
+	svr := NewServer()
+	svr.StaticFS("/ui", fsys, StaticFSOptions{SPAFallback: true, Precompressed: true})
+	engine := svr.GetGinEngine()
+
+	w := doRequest(engine, http.MethodGet, "/ui/app.3f9a2c1b.js", nil)
+	assertTrue(t, w.Body.String() == "console.log(1)", "plain file expected, got %s", w.Body)
+	assertTrue(t, w.Header().Get("Cache-Control") == "public, max-age=31536000, immutable", "fingerprinted file should be immutable, got %s", w.Header().Get("Cache-Control"))
+
+	w = doRequest(engine, http.MethodGet, "/ui/app.3f9a2c1b.js", map[string]string{"Accept-Encoding": "gzip"})
+	assertTrue(t, w.Body.String() == "gzipped" && w.Header().Get("Content-Encoding") == "gzip", "precompressed file expected, got %s", w.Body)
+	assertTrue(t, w.Header().Get("Vary") == "Accept-Encoding", "Vary header expected")
+
+	w = doRequest(engine, http.MethodGet, "/ui/orders/42", nil)
+	assertTrue(t, w.Body.String() == "<html>app</html>", "SPA fallback expected, got %d %s", w.Code, w.Body)
+	etag := w.Header().Get("ETag")
+	assertTrue(t, w.Header().Get("Cache-Control") == "no-cache" && etag != "", "index should be revalidated with an ETag")
+
+	w = doRequest(engine, http.MethodGet, "/ui/", map[string]string{"If-None-Match": etag})
+	assertTrue(t, w.Code == http.StatusNotModified, "matching ETag should return 304, got %d", w.Code)
+
+	w = doRequest(engine, http.MethodGet, "/ui/missing.png", nil)
+	assertTrue(t, w.Code == http.StatusNotFound, "missing asset should return 404, got %d", w.Code)
+}
+
+func TestStaticFSAtRoot(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":      {Data: []byte("<html>app</html>")},
+		"assets/logo.svg": {Data: []byte("<svg/>")},
+	}
+	mod := NewModule("/api")
+	mod.Register(&IdemTest{})
+	svr := NewServer()
+	svr.RegisterModule(mod)
+	svr.StaticFS("/", fsys, StaticFSOptions{SPAFallback: true})
+	engine := svr.GetGinEngine()
+
+	w := doRequest(engine, http.MethodGet, "/assets/logo.svg", nil)
+	assertTrue(t, w.Body.String() == "<svg/>", "file expected, got %d %s", w.Code, w.Body)
+	etag := w.Header().Get("ETag")
+	w = doRequest(engine, http.MethodGet, "/assets/logo.svg", map[string]string{"If-None-Match": etag})
+	assertTrue(t, w.Code == http.StatusNotModified, "ETag should be stable, got %d", w.Code)
+
+	w = doRequest(engine, http.MethodGet, "/", nil)
+	assertTrue(t, w.Body.String() == "<html>app</html>", "index expected, got %d %s", w.Code, w.Body)
+	w = doRequest(engine, http.MethodGet, "/orders/42", nil)
+	assertTrue(t, w.Body.String() == "<html>app</html>", "SPA fallback expected, got %d %s", w.Code, w.Body)
+	w = doRequest(engine, http.MethodPost, "/orders/42", nil)
+	assertTrue(t, w.Code == http.StatusNotFound, "only GET and HEAD should be served, got %d", w.Code)
+
+	w = doRequest(engine, http.MethodPost, "/api/idem_test/create/", nil)
+	assertTrue(t, strings.Contains(w.Body.String(), `"result":0`), "modules should still be served, got %s", w.Body)
+}
@@ -1,14 +1,31 @@
 package niuhe
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	DefaultShutdownTimeout = 30 * time.Second
+	DefaultRestartTimeout  = 30 * time.Second
+)
+
+type servedListener struct {
+	addr string
+	ln   net.Listener
+}
+
 type Server struct {
 	PathPrefix         string
 	engine             *gin.Engine
@@ -17,6 +34,31 @@ type Server struct {
 	niuheMiddlewares   []HandlerFunc
 	staticPaths        []staticPath
 	customLogFormatter func(param gin.LogFormatterParams) string
+	shutdownTimeout    time.Duration
+	restartTimeout     time.Duration
+	shutdownHooks      []func(context.Context) error
+	lock               sync.Mutex
+	httpServers        []*http.Server
+	listeners          []servedListener
+	shuttingDown       bool
+	shutdownOnce       sync.Once
+	shutdownDone       chan struct{}
+	shutdownErr        error
+	unixSocketOptions  UnixSocketOptions
+	tlsOptions         *TLSOptions
+	listenSpecs        []listenSpec
+	health             *healthState
+	shutdownDelay      time.Duration
+	metrics            *Metrics
+	metricsPath        string
+	accessLog          *AccessLogOptions
+	requestID          gin.HandlerFunc
+	compression        gin.HandlerFunc
+	vhosts             []*VirtualHost
+	handler            http.Handler
+	handlerOnce        sync.Once
+	maintenance        *maintenanceState
+	writeErrorHook     WriteErrorHook
 }
 
 func NewServer() *Server {
@@ -25,6 +67,12 @@ func NewServer() *Server {
 		middlewares:      make([]gin.HandlerFunc, 0),
 		niuheMiddlewares: make([]HandlerFunc, 0),
 		staticPaths:      make([]staticPath, 0),
+		shutdownTimeout:  DefaultShutdownTimeout,
+		restartTimeout:   DefaultRestartTimeout,
+		shutdownDone:     make(chan struct{}),
+		unixSocketOptions: UnixSocketOptions{
+			Mode: 0777,
+		},
 	}
 }
 
@@ -51,27 +99,194 @@ func (svr *Server) RegisterModule(mod *Module) {
 	svr.modules = append(svr.modules, mod)
 }
 
-func (svr *Server) Serve(addr string) {
-	ginEngine := svr.GetGinEngine()
-	if strings.HasPrefix(addr, "unix:") {
-		filename := addr[5:]
-		go func() {
-			time.Sleep(1 * time.Second)
-			os.Chmod(filename, 0777)
-		}()
-		ginEngine.RunUnix(filename)
+// ListenOptions configures an extra address served by Serve.
+type ListenOptions struct {
+	// Modules served on this address. When empty, the modules registered with
+	// RegisterModule are served. Modules listed here need not be registered,
+	// e.g. to keep an admin module off the public address.
+	Modules []*Module
+	TLS     *TLSOptions // overrides SetTLS for this address
+}
+
+type listenSpec struct {
+	addr string
+	opts ListenOptions
+}
+
+// AddListener makes Serve listen on addr as well, with its own modules and TLS settings.
+func (svr *Server) AddListener(addr string, opts ListenOptions) *Server {
+	svr.listenSpecs = append(svr.listenSpecs, listenSpec{addr, opts})
+	return svr
+}
+
+// Serve listens on addr ("unix:/path/to/sock" for a unix socket) and on the
+// addresses added by AddListener; addr may be empty when those are enough.
+// It blocks until SIGINT or SIGTERM is received or Shutdown is called, and
+// in-flight requests are drained before it returns. SIGUSR2 hands the
+// listeners over to a new instance of the executable, see Restart.
+func (svr *Server) Serve(addr string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	svr.watchRestartSignal(stopWatch)
+	return svr.ServeContext(ctx, addr)
+}
+
+// ServeContext is like Serve but shuts down when ctx is done instead of on signals.
+func (svr *Server) ServeContext(ctx context.Context, addr string) error {
+	specs := svr.listenSpecs
+	if addr != "" {
+		specs = append([]listenSpec{{addr: addr}}, specs...)
+	}
+	if len(specs) == 0 {
+		return fmt.Errorf("niuhe: no address to serve")
+	}
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	errCh := make(chan error, len(specs))
+	for _, spec := range specs {
+		if err := svr.startListener(spec, errCh, stopWatch); err != nil {
+			svr.shutdownWithTimeout()
+			return err
+		}
+	}
+	notifyReady()
+	select {
+	case err := <-errCh:
+		if err != http.ErrServerClosed {
+			svr.shutdownWithTimeout()
+			return err
+		}
+		<-svr.shutdownDone // Shutdown was called elsewhere, wait for draining
+		return svr.shutdownErr
+	case <-ctx.Done():
+		return svr.shutdownWithTimeout()
+	}
+}
+
+func (svr *Server) startListener(spec listenSpec, errCh chan<- error, stopWatch <-chan struct{}) error {
+	ln, err := svr.listen(spec.addr)
+	if err != nil {
+		return err
+	}
+	servedLn := ln
+	tlsOptions := svr.tlsOptions
+	if spec.opts.TLS != nil {
+		tlsOptions = spec.opts.TLS
+	}
+	if tlsOptions != nil {
+		reloader, err := newCertReloader(*tlsOptions)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		go reloader.watch(stopWatch)
+		servedLn = tls.NewListener(ln, reloader.tlsConfig())
+	}
+	var handler http.Handler
+	if len(spec.opts.Modules) > 0 {
+		handler = svr.buildEngine(engineSpec{modules: spec.opts.Modules})
 	} else {
-		ginEngine.Run(addr)
+		handler = svr.Handler()
 	}
+	httpSvr := &http.Server{Handler: handler}
+	svr.lock.Lock()
+	if svr.shuttingDown {
+		svr.lock.Unlock()
+		ln.Close()
+		return http.ErrServerClosed
+	}
+	svr.httpServers = append(svr.httpServers, httpSvr)
+	svr.listeners = append(svr.listeners, servedListener{spec.addr, ln})
+	svr.lock.Unlock()
+	go func() {
+		errCh <- httpSvr.Serve(servedLn)
+	}()
+	return nil
+}
+
+func (svr *Server) shutdownWithTimeout() error {
+	ctx, cancel := context.WithTimeout(context.Background(), svr.shutdownTimeout)
+	defer cancel()
+	return svr.Shutdown(ctx)
+}
+
+// SetShutdownTimeout sets how long Serve waits for in-flight requests when
+// it is stopped by a signal or by its context.
+func (svr *Server) SetShutdownTimeout(timeout time.Duration) {
+	svr.shutdownTimeout = timeout
+}
+
+// SetRestartTimeout sets how long Restart waits for the new instance to
+// serve before giving up and keeping this one.
+func (svr *Server) SetRestartTimeout(timeout time.Duration) {
+	svr.restartTimeout = timeout
+}
+
+// SetShutdownDelay keeps serving for delay after Shutdown is called while
+// the readiness endpoint already fails, so load balancers can stop routing
+// requests before the listeners close.
+func (svr *Server) SetShutdownDelay(delay time.Duration) {
+	svr.shutdownDelay = delay
+}
+
+// OnShutdown registers a hook run after the listeners are drained, e.g. to
+// close database engines. Hooks run in reverse order of registration.
+func (svr *Server) OnShutdown(hook func(ctx context.Context) error) {
+	svr.shutdownHooks = append(svr.shutdownHooks, hook)
+}
+
+// ShuttingDown reports whether Shutdown has been called.
+func (svr *Server) ShuttingDown() bool {
+	svr.lock.Lock()
+	defer svr.lock.Unlock()
+	return svr.shuttingDown
+}
+
+// Shutdown stops accepting connections, waits for in-flight requests until
+// ctx is done and then runs the shutdown hooks. It returns the first error met.
+func (svr *Server) Shutdown(ctx context.Context) error {
+	svr.shutdownOnce.Do(func() {
+		svr.lock.Lock()
+		svr.shuttingDown = true
+		httpServers := svr.httpServers
+		svr.lock.Unlock()
+		if svr.shutdownDelay > 0 && len(httpServers) > 0 {
+			select {
+			case <-time.After(svr.shutdownDelay):
+			case <-ctx.Done():
+			}
+		}
+		var firstErr error
+		for _, httpSvr := range httpServers {
+			if err := httpSvr.Shutdown(ctx); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		for i := len(svr.shutdownHooks) - 1; i >= 0; i-- {
+			if err := svr.shutdownHooks[i](ctx); err != nil {
+				LogError("shutdown hook failed: %v", err)
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+		svr.shutdownErr = firstErr
+		close(svr.shutdownDone)
+	})
+	<-svr.shutdownDone
+	return svr.shutdownErr
 }
 
 type staticPath struct {
 	relativePath string
 	root         string
+	fs           *staticFS
 }
 
 func (svr *Server) Static(relativePath, root string) {
-	svr.staticPaths = append(svr.staticPaths, staticPath{relativePath, root})
+	svr.staticPaths = append(svr.staticPaths, staticPath{relativePath: relativePath, root: root})
 }
 
 func (svr *Server) SetCustomLogFormatter(formatter func(gin.LogFormatterParams) string) {
@@ -103,39 +318,99 @@ func (svr *Server) logFormatter(param gin.LogFormatterParams) string {
 	)
 }
 
+// GetGinEngine returns the engine serving the modules registered with
+// RegisterModule. Virtual hosts have engines of their own, see Handler.
 func (svr *Server) GetGinEngine(loggerConfig ...gin.LoggerConfig) *gin.Engine {
 	if svr.engine == nil {
-		svr.engine = gin.New()
-		for _, sp := range svr.staticPaths {
-			svr.engine.Static(sp.relativePath, sp.root)
+		svr.engine = svr.buildEngine(engineSpec{modules: svr.modules})
+	}
+	return svr.engine
+}
+
+// engineSpec describes what an engine serves on top of the server-wide
+// static paths and middlewares.
+type engineSpec struct {
+	modules          []*Module
+	middlewares      []gin.HandlerFunc
+	niuheMiddlewares []HandlerFunc
+	pf               IApiProtocolFactory
+}
+
+func (svr *Server) buildEngine(spec engineSpec) *gin.Engine {
+	engine := gin.New()
+	if svr.requestID != nil {
+		engine.Use(svr.requestID)
+	}
+	for _, sp := range svr.staticPaths {
+		if sp.fs != nil {
+			mount := strings.TrimSuffix(sp.relativePath, "/")
+			if mount == "" {
+				engine.NoRoute(sp.fs.handleNoRoute)
+				continue
+			}
+			engine.GET(mount+"/*filepath", sp.fs.handle)
+			engine.HEAD(mount+"/*filepath", sp.fs.handle)
+		} else {
+			engine.Static(sp.relativePath, sp.root)
 		}
+	}
+	if svr.accessLog != nil {
+		engine.Use(accessLogMiddleware(svr.accessLog))
+	} else {
 		loggerConfig := gin.LoggerConfig{
 			Formatter: svr.logFormatter,
 			Output:    os.Stderr,
 		}
-		svr.engine.Use(gin.LoggerWithConfig(loggerConfig), gin.Recovery()).
-			Use(svr.middlewares...)
-		for _, mod := range svr.modules {
-			group := svr.engine.Group(svr.PathPrefix + mod.urlPrefix)
-			for _, info := range mod.Routers(svr.niuheMiddlewares) {
-				path2 := info.Path // another path with or without suffix "/"
-
-				if strings.HasSuffix(info.Path, "/") {
-					path2 = strings.TrimSuffix(info.Path, "/")
-				} else {
-					path2 = info.Path + "/"
-				}
+		engine.Use(gin.LoggerWithConfig(loggerConfig))
+	}
+	engine.Use(gin.Recovery())
+	svr.registerHealth(engine)
+	if svr.metrics != nil {
+		engine.GET(svr.metricsPath, gin.WrapH(svr.metrics))
+		engine.Use(svr.metrics.Middleware())
+	}
+	if svr.compression != nil {
+		engine.Use(svr.compression)
+	}
+	engine.Use(svr.middlewares...).Use(spec.middlewares...)
+	niuheMiddlewares := append(append([]HandlerFunc{}, svr.niuheMiddlewares...), spec.niuheMiddlewares...)
+	var maintenanceMiddlewares []HandlerFunc
+	if svr.maintenance != nil {
+		svr.maintenance.start()
+		maintenanceMiddlewares = append([]HandlerFunc{svr.maintenance.middleware}, niuheMiddlewares...)
+	}
+	svrPrefix := strings.TrimSuffix(svr.PathPrefix, "/")
+	for _, mod := range spec.modules {
+		group := engine.Group(svr.PathPrefix + mod.urlPrefix)
+		modMiddlewares := niuheMiddlewares
+		if maintenanceMiddlewares != nil && !mod.skipMaintenance {
+			modMiddlewares = maintenanceMiddlewares
+		}
+		for _, info := range mod.routersWithDefault(modMiddlewares, spec.pf) {
+			info.svrPrefix = svrPrefix
+			if info.writeErrorHook == nil {
+				info.writeErrorHook = svr.writeErrorHook
+			}
+			path2 := info.Path // another path with or without suffix "/"
 
-				if (info.Methods & GET) != 0 {
-					group.GET(info.Path, info.HandleFunc)
-					group.GET(path2, info.HandleFunc)
-				}
-				if (info.Methods & POST) != 0 {
-					group.POST(info.Path, info.HandleFunc)
-					group.POST(path2, info.HandleFunc)
-				}
+			if strings.HasSuffix(info.Path, "/") {
+				path2 = strings.TrimSuffix(info.Path, "/")
+			} else {
+				path2 = info.Path + "/"
+			}
+
+			if (info.Methods & GET) != 0 {
+				group.GET(info.Path, info.HandleFunc)
+				group.GET(path2, info.HandleFunc)
+			}
+			if (info.Methods & POST) != 0 {
+				group.POST(info.Path, info.HandleFunc)
+				group.POST(path2, info.HandleFunc)
 			}
 		}
+		if mod.jsonrpcPath != "" {
+			group.POST(mod.jsonrpcPath, mod.jsonrpcHandler(svrPrefix))
+		}
 	}
-	return svr.engine
+	return engine
 }
@@ -0,0 +1,74 @@
+package niuhe
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServeContextShutdown(t *testing.T) {
+	svr := NewServer()
+	var order []int
+	svr.OnShutdown(func(context.Context) error {
+		order = append(order, 1)
+		return nil
+	})
+	svr.OnShutdown(func(context.Context) error {
+		order = append(order, 2)
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- svr.ServeContext(ctx, "127.0.0.1:0")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errCh:
+		assertTrue(t, err == nil, "ServeContext should stop gracefully, got %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeContext did not return after cancel")
+	}
+	assertTrue(t, svr.ShuttingDown(), "server should be shutting down")
+	assertTrue(t, len(order) == 2 && order[0] == 2 && order[1] == 1, "hooks should run in reverse order, got %v", order)
+}
+
+func TestServeMultipleListeners(t *testing.T) {
+	public := NewModule("/api")
+	public.Register(&IdemTest{})
+	admin := NewModule("/admin")
+	admin.Register(&CacheTest{})
+	svr := NewServer()
+	svr.RegisterModule(public)
+	svr.AddListener("127.0.0.1:0", ListenOptions{Modules: []*Module{admin}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go svr.ServeContext(ctx, "127.0.0.1:0")
+	var addrs []string
+	for i := 0; i < 100 && len(addrs) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		svr.lock.Lock()
+		addrs = addrs[:0]
+		for _, l := range svr.listeners {
+			addrs = append(addrs, l.ln.Addr().String())
+		}
+		svr.lock.Unlock()
+	}
+	if len(addrs) != 2 {
+		t.Fatalf("expected 2 listeners, got %v", addrs)
+	}
+	status := func(addr, path string) int {
+		rsp, err := http.Post("http://"+addr+path, "application/x-www-form-urlencoded", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+		return rsp.StatusCode
+	}
+	assertTrue(t, status(addrs[0], "/api/idem_test/create/") == 200, "public module should be served on the main address")
+	assertTrue(t, status(addrs[0], "/admin/cache_test/item/") == 404, "admin module should not be served on the main address")
+	assertTrue(t, status(addrs[1], "/api/idem_test/create/") == 404, "public module should not be served on the admin address")
+}
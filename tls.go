@@ -0,0 +1,177 @@
+package niuhe
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const DefaultTLSReloadInterval = 10 * time.Second
+
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables client certificate verification for
+	// service-to-service calls.
+	ClientCAFile string
+	// ClientAuth defaults to tls.RequireAndVerifyClientCert when ClientCAFile is set.
+	ClientAuth tls.ClientAuthType
+	MinVersion uint16 // defaults to TLS 1.2
+	// ReloadInterval is how often the files are checked for changes, 0 for
+	// DefaultTLSReloadInterval and negative to reload only on SIGHUP.
+	ReloadInterval time.Duration
+}
+
+// SetTLS makes Serve answer HTTPS. Certificates are reloaded when the files
+// change or on SIGHUP, without restarting.
+func (svr *Server) SetTLS(opts TLSOptions) {
+	svr.tlsOptions = &opts
+}
+
+type certReloader struct {
+	opts   TLSOptions
+	lock   sync.RWMutex
+	cert   *tls.Certificate
+	stamps map[string]time.Time
+	// clientConfig holds the *tls.Config verifying client certificates with
+	// the loaded CAs; it is replaced on reload only, so handshakes share it.
+	clientConfig atomic.Value
+}
+
+func newCertReloader(opts TLSOptions) (*certReloader, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, fmt.Errorf("niuhe: TLS needs both CertFile and KeyFile")
+	}
+	if opts.ClientCAFile != "" && opts.ClientAuth == tls.NoClientCert {
+		opts.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if opts.MinVersion == 0 {
+		opts.MinVersion = tls.VersionTLS12
+	}
+	if opts.ReloadInterval == 0 {
+		opts.ReloadInterval = DefaultTLSReloadInterval
+	}
+	r := &certReloader{opts: opts}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.opts.CertFile, r.opts.KeyFile}
+	if r.opts.ClientCAFile != "" {
+		files = append(files, r.opts.ClientCAFile)
+	}
+	return files
+}
+
+func (r *certReloader) reload() error {
+	stamps := make(map[string]time.Time)
+	for _, name := range r.files() {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		stamps[name] = info.ModTime()
+	}
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return err
+	}
+	var clientCAs *x509.CertPool
+	if r.opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("niuhe: no certificate found in %s", r.opts.ClientCAFile)
+		}
+	}
+	r.lock.Lock()
+	r.cert, r.stamps = &cert, stamps
+	r.lock.Unlock()
+	if clientCAs != nil {
+		config := r.baseConfig()
+		config.ClientCAs = clientCAs
+		r.clientConfig.Store(config)
+	}
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+func (r *certReloader) baseConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     r.opts.MinVersion,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: r.getCertificate,
+		ClientAuth:     r.opts.ClientAuth,
+	}
+}
+
+func (r *certReloader) changed() bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for name, stamp := range r.stamps {
+		if info, err := os.Stat(name); err == nil && !info.ModTime().Equal(stamp) {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsConfig returns the config of a listener. The certificate is looked up
+// per handshake, while the config itself stays the same so that sessions
+// can be resumed.
+func (r *certReloader) tlsConfig() *tls.Config {
+	config := r.baseConfig()
+	if r.opts.ClientCAFile != "" {
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.clientConfig.Load().(*tls.Config), nil
+		}
+	}
+	return config
+}
+
+// watch reloads the certificates on file changes and SIGHUP until stop is closed.
+// A failed reload keeps serving the previous certificates.
+func (r *certReloader) watch(stop <-chan struct{}) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+	var tick <-chan time.Time
+	if r.opts.ReloadInterval > 0 {
+		ticker := time.NewTicker(r.opts.ReloadInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick:
+			if !r.changed() {
+				continue
+			}
+		case <-sigCh:
+		}
+		if err := r.reload(); err != nil {
+			LogError("reload TLS certificate %s failed: %v", r.opts.CertFile, err)
+		} else {
+			LogInfo("TLS certificate %s reloaded", r.opts.CertFile)
+		}
+	}
+}
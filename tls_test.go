@@ -0,0 +1,84 @@
+package niuhe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCert(t *testing.T, dir, cn string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "first")
+	r, err := newCertReloader(TLSOptions{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTrue(t, !r.changed(), "fresh reloader should not see changes")
+
+	writeTestCert(t, dir, "second")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	assertTrue(t, r.changed(), "rewritten certificate should be detected")
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := r.tlsConfig().GetCertificate(nil)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTrue(t, leaf.Subject.CommonName == "second", "reloaded certificate should be served, got %s", leaf.Subject.CommonName)
+}
+
+func TestCertReloaderClientConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "server")
+	r, err := newCertReloader(TLSOptions{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := r.tlsConfig()
+	first, _ := config.GetConfigForClient(nil)
+	second, _ := config.GetConfigForClient(nil)
+	assertTrue(t, first == second, "handshakes should share the client config")
+	assertTrue(t, first.ClientAuth == tls.RequireAndVerifyClientCert && first.ClientCAs != nil, "client certificates should be verified")
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, _ := config.GetConfigForClient(nil)
+	assertTrue(t, reloaded != first && reloaded.ClientCAs != nil, "reload should swap the client config")
+}
@@ -0,0 +1,116 @@
+package niuhe
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VirtualHost groups modules served only for requests whose Host header
+// matches one of its hostnames. A hostname may start with "*." to match
+// every subdomain.
+type VirtualHost struct {
+	hosts            []string
+	modules          []*Module
+	middlewares      []gin.HandlerFunc
+	niuheMiddlewares []HandlerFunc
+	pf               IApiProtocolFactory
+}
+
+// VirtualHost creates a virtual host for hostnames. Requests for other hosts
+// are served by the modules registered on the server itself.
+func (svr *Server) VirtualHost(hostnames ...string) *VirtualHost {
+	vh := &VirtualHost{}
+	for _, host := range hostnames {
+		vh.hosts = append(vh.hosts, strings.ToLower(host))
+	}
+	svr.vhosts = append(svr.vhosts, vh)
+	return vh
+}
+
+func (vh *VirtualHost) Use(middlewares ...gin.HandlerFunc) *VirtualHost {
+	vh.middlewares = append(vh.middlewares, middlewares...)
+	return vh
+}
+
+func (vh *VirtualHost) UseNiuhe(middlewares ...HandlerFunc) *VirtualHost {
+	vh.niuheMiddlewares = append(vh.niuheMiddlewares, middlewares...)
+	return vh
+}
+
+// SetProtocolFactory sets the protocol of routes registered without one.
+func (vh *VirtualHost) SetProtocolFactory(pf IApiProtocolFactory) *VirtualHost {
+	vh.pf = pf
+	return vh
+}
+
+func (vh *VirtualHost) RegisterModule(mod *Module) *VirtualHost {
+	vh.modules = append(vh.modules, mod)
+	return vh
+}
+
+type hostRouter struct {
+	exact    map[string]http.Handler
+	wildcard map[string]http.Handler // keyed by ".example.com"
+	fallback http.Handler
+}
+
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func (hr *hostRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := requestHost(r)
+	if handler, exists := hr.exact[host]; exists {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	for idx := strings.IndexByte(host, '.'); idx >= 0; idx = strings.IndexByte(host, '.') {
+		host = host[idx:]
+		if handler, exists := hr.wildcard[host]; exists {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		host = host[1:]
+	}
+	hr.fallback.ServeHTTP(w, r)
+}
+
+// Handler returns the http.Handler used by Serve: the engine of
+// GetGinEngine, dispatched by Host header when virtual hosts are defined.
+func (svr *Server) Handler() http.Handler {
+	svr.handlerOnce.Do(func() {
+		if len(svr.vhosts) == 0 {
+			svr.handler = svr.GetGinEngine()
+			return
+		}
+		hr := &hostRouter{
+			exact:    make(map[string]http.Handler),
+			wildcard: make(map[string]http.Handler),
+			fallback: svr.GetGinEngine(),
+		}
+		for _, vh := range svr.vhosts {
+			engine := svr.buildEngine(engineSpec{
+				modules:          vh.modules,
+				middlewares:      vh.middlewares,
+				niuheMiddlewares: vh.niuheMiddlewares,
+				pf:               vh.pf,
+			})
+			for _, host := range vh.hosts {
+				if strings.HasPrefix(host, "*.") {
+					hr.wildcard[host[1:]] = engine
+				} else {
+					hr.exact[host] = engine
+				}
+			}
+		}
+		svr.handler = hr
+	})
+	return svr.handler
+}
@@ -0,0 +1,31 @@
+package niuhe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	svr := NewServer()
+	svr.RegisterModule(NewModule("/api").Register(&VhostTest{name: "default"}))
+	svr.VirtualHost("api.example.com").RegisterModule(NewModule("/api").Register(&VhostTest{name: "api"}))
+	svr.VirtualHost("*.tenant.example.com").RegisterModule(NewModule("/api").Register(&VhostTest{name: "tenant"}))
+	handler := svr.Handler()
+
+	for host, expected := range map[string]string{
+		"api.example.com":        "api",
+		"API.example.com:8080":   "api",
+		"a.tenant.example.com":   "tenant",
+		"a.b.tenant.example.com": "tenant",
+		"tenant.example.com":     "default",
+		"other.example.com":      "default",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/vhost_test/name/", nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assertTrue(t, strings.Contains(w.Body.String(), `"name":"`+expected+`"`), "host %s should be served by %s, got %s", host, expected, w.Body)
+	}
+}
@@ -0,0 +1,114 @@
+package niuhe
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+)
+
+type writeErrorTestRsp struct {
+	Ratio float64 `json:"ratio"`
+}
+
+type WriteErrorTest struct{}
+
+func (api *WriteErrorTest) Ratio(c *Context, req *struct{}, rsp *writeErrorTestRsp) error {
+	rsp.Ratio = math.NaN()
+	return nil
+}
+
+func TestWriteErrorFallback(t *testing.T) {
+	var hookErr error
+	var hookType reflect.Type
+	var logBuf bytes.Buffer
+	SetLogOutput(&logBuf)
+	defer SetLogOutput(os.Stderr)
+
+	svr := NewServer()
+	svr.SetWriteErrorHook(func(c *Context, err error, rsp reflect.Value) {
+		t.Errorf("module hook should override the server one")
+	})
+	svr.RegisterModule(NewModule("/api").Register(&WriteErrorTest{}).EnableJSONRPC("/rpc").
+		SetWriteErrorHook(func(c *Context, err error, rsp reflect.Value) {
+			hookErr, hookType = err, rsp.Type()
+		}))
+	engine := svr.GetGinEngine()
+
+	w := doRequest(engine, http.MethodGet, "/api/write_error_test/ratio/", nil)
+	assertTrue(t, w.Code == http.StatusInternalServerError, "status should be 500, got %d", w.Code)
+	assertTrue(t, w.Body.String() == `{"message":"failed to write response","result":-1}`, "unexpected fallback body %s", w.Body)
+	assertTrue(t, hookErr != nil && hookType == reflect.TypeOf(&writeErrorTestRsp{}), "hook should see the failure, got %v %v", hookErr, hookType)
+	assertTrue(t, strings.Contains(logBuf.String(), "value NaN"), "log should name the value, got %s", logBuf.String())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/rpc", strings.NewReader(`{"jsonrpc":"2.0","method":"write_error_test.ratio","id":1}`))
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	assertTrue(t, strings.Contains(rec.Body.String(), `"code":-32603`), "rpc call should get an internal error, got %s", rec.Body)
+}
+
+// badSelfer fails to encode as MessagePack.
+type badSelfer struct{}
+
+func (badSelfer) CodecEncodeSelf(e *codec.Encoder) {
+	panic(errors.New("boom"))
+}
+
+func (*badSelfer) CodecDecodeSelf(d *codec.Decoder) {}
+
+type writeErrorSelferRsp struct {
+	Value badSelfer `json:"value"`
+}
+
+func (api *WriteErrorTest) Selfer(c *Context, req *struct{}, rsp *writeErrorSelferRsp) error {
+	return nil
+}
+
+func TestWriteErrorFallbackProtocols(t *testing.T) {
+	SetLogOutput(io.Discard)
+	defer SetLogOutput(os.Stderr)
+
+	svr := NewServer()
+	svr.RegisterModule(NewModuleWithProtocolFactory("/mp", MsgpackApiProtocolFactory).Register(&WriteErrorTest{}))
+	svr.RegisterModule(NewModuleWithProtocolFactory("/rest", RestApiProtocolFactory).Register(&WriteErrorTest{}))
+	engine := svr.GetGinEngine()
+
+	w := doRequest(engine, http.MethodGet, "/mp/write_error_test/selfer/", nil)
+	assertTrue(t, w.Code == http.StatusInternalServerError && w.Header().Get("Content-Type") == MsgpackContentType, "msgpack fallback expected, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	var env map[string]interface{}
+	if err := codec.NewDecoderBytes(w.Body.Bytes(), msgpackHandle).Decode(&env); err != nil {
+		t.Fatal(err)
+	}
+	assertTrue(t, fmt.Sprint(env["result"]) == "-1" && env["message"] == "failed to write response", "unexpected msgpack fallback %v", env)
+
+	w = doRequest(engine, http.MethodGet, "/rest/write_error_test/ratio/", nil)
+	assertTrue(t, w.Code == http.StatusInternalServerError && w.Header().Get("Content-Type") == ProblemContentType, "problem fallback expected, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	assertTrue(t, strings.Contains(w.Body.String(), `"status":500`), "unexpected problem %s", w.Body)
+}
+
+func TestDescribeWriteError(t *testing.T) {
+	_, err := json.Marshal(map[string]interface{}{"ch": make(chan int)})
+	assertTrue(t, describeWriteError(fmt.Errorf("encode: %w", err)) == "unsupported type chan int", "got %s", describeWriteError(err))
+	cyclic := map[string]interface{}{}
+	cyclic["self"] = cyclic
+	_, err = json.Marshal(cyclic)
+	assertTrue(t, strings.Contains(describeWriteError(err), "cycle via map[string]interface {}"), "got %s", describeWriteError(err))
+	_, err = json.Marshal(badMarshaler{})
+	assertTrue(t, strings.Contains(describeWriteError(err), "niuhe.badMarshaler: boom"), "got %s", describeWriteError(err))
+}
+
+type badMarshaler struct{}
+
+func (badMarshaler) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("boom")
+}